@@ -1,29 +1,220 @@
 package configuration
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/caarlos0/env/v8"
 	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+
+	"github.com/bizflycloud/external-dns-bizflycloud-webhook/internal/bizflycloud"
+	"github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/tracing"
 )
 
-// Config struct for configuration environmental variables
+// Config struct for configuration environmental variables, which may also be read from a YAML or JSON file
 type Config struct {
-	ServerHost           string        `env:"SERVER_HOST" envDefault:"localhost"`
-	ServerPort           int           `env:"SERVER_PORT" envDefault:"8888"`
-	ServerReadTimeout    time.Duration `env:"SERVER_READ_TIMEOUT"`
-	ServerWriteTimeout   time.Duration `env:"SERVER_WRITE_TIMEOUT"`
-	DomainFilter         []string      `env:"DOMAIN_FILTER" envDefault:""`
-	ExcludeDomains       []string      `env:"EXCLUDE_DOMAIN_FILTER" envDefault:""`
-	RegexDomainFilter    string        `env:"REGEXP_DOMAIN_FILTER" envDefault:""`
-	RegexDomainExclusion string        `env:"REGEXP_DOMAIN_FILTER_EXCLUSION" envDefault:""`
-}
-
-// Init sets up configuration by reading set environmental variables
-func Init() Config {
-	cfg := Config{}
-	if err := env.Parse(&cfg); err != nil {
-		log.Fatalf("Error reading configuration from environment: %v", err)
+	ServerHost              string        `env:"SERVER_HOST" envDefault:"localhost" yaml:"serverHost"`
+	ServerPort              int           `env:"SERVER_PORT" envDefault:"8888" yaml:"serverPort"`
+	ServerReadTimeout       time.Duration `env:"SERVER_READ_TIMEOUT" yaml:"serverReadTimeout"`
+	ServerWriteTimeout      time.Duration `env:"SERVER_WRITE_TIMEOUT" yaml:"serverWriteTimeout"`
+	ServerTLSCertFile       string        `env:"SERVER_TLS_CERT_FILE" yaml:"serverTLSCertFile"`
+	ServerTLSKeyFile        string        `env:"SERVER_TLS_KEY_FILE" yaml:"serverTLSKeyFile"`
+	ServerTLSReloadInterval time.Duration `env:"SERVER_TLS_RELOAD_INTERVAL" envDefault:"30s" yaml:"serverTLSReloadInterval"`
+	AuthToken               string        `env:"AUTH_TOKEN" yaml:"authToken"`
+	AuthTokenFile           string        `env:"AUTH_TOKEN_FILE" yaml:"authTokenFile"`
+	AuthClientCAFile        string        `env:"AUTH_CLIENT_CA_FILE" yaml:"authClientCAFile"`
+	DomainFilter            []string      `env:"DOMAIN_FILTER" envDefault:"" yaml:"domainFilter"`
+	ExcludeDomains          []string      `env:"EXCLUDE_DOMAIN_FILTER" envDefault:"" yaml:"excludeDomainFilter"`
+	RegexDomainFilter       string        `env:"REGEXP_DOMAIN_FILTER" envDefault:"" yaml:"regexpDomainFilter"`
+	RegexDomainExclusion    string        `env:"REGEXP_DOMAIN_FILTER_EXCLUSION" envDefault:"" yaml:"regexpDomainFilterExclusion"`
+	ZoneIDFilter            []string      `env:"ZONE_ID_FILTER" envDefault:"" yaml:"zoneIDFilter"`
+	// MetricsPort serves /metrics on a separate port, when 0 it is served next to the webhook
+	MetricsPort int `env:"METRICS_PORT" envDefault:"0" yaml:"metricsPort"`
+	// TracingExporter is one of none, otlp or stdout, the OTLP exporter is set up by the OTEL_EXPORTER_OTLP_* variables
+	TracingExporter    string  `env:"TRACING_EXPORTER" envDefault:"none" yaml:"tracingExporter"`
+	TracingSampleRatio float64 `env:"TRACING_SAMPLE_RATIO" envDefault:"1" yaml:"tracingSampleRatio"`
+	// LogLevel is a logrus level name or number, LogFormat is text or json
+	LogLevel  string `env:"LOG_LEVEL" envDefault:"info" yaml:"logLevel"`
+	LogFormat string `env:"LOG_FORMAT" envDefault:"text" yaml:"logFormat"`
+	// Provider holds the settings of the Bizfly Cloud provider
+	Provider bizflycloud.Configuration `yaml:"provider"`
+}
+
+// Init sets up configuration by reading the configuration file, if any, and the set environmental variables.
+// It exits listing every invalid setting if the configuration is not valid.
+func Init(file string) Config {
+	cfg, err := Load(file, Environ())
+	if err != nil {
+		for _, message := range strings.Split(err.Error(), "\n") {
+			log.Error(message)
+		}
+		log.Fatal("Invalid configuration")
 	}
 	return cfg
 }
+
+// Load reads the configuration file and overrides its values with the variables set in the environment.
+// Settings missing from both get their defaults. All parse and validation errors are returned together.
+func Load(file string, environment map[string]string) (Config, error) {
+	// defaults first, so that the file only overrides the settings it holds
+	cfg := Config{}
+	if err := env.ParseWithOptions(&cfg, env.Options{Environment: map[string]string{}}); err != nil {
+		return cfg, err
+	}
+	var errs []error
+	if file != "" {
+		if err := readFile(file, &cfg); err != nil {
+			// unknown settings and values of the wrong type are reported along with the other errors
+			var typeErr *yaml.TypeError
+			if !errors.As(err, &typeErr) {
+				return cfg, err
+			}
+			errs = append(errs, err)
+		}
+	}
+
+	// variables that fail to parse keep the value of the file and are reported along with the invalid settings
+	failed := map[string]bool{}
+	var fromEnv Config
+	if err := env.ParseWithOptions(&fromEnv, env.Options{Environment: environment}); err != nil {
+		var aggregate env.AggregateError
+		if !errors.As(err, &aggregate) {
+			return cfg, err
+		}
+		for _, err := range aggregate.Errors {
+			var parseErr env.ParseError
+			if errors.As(err, &parseErr) {
+				key := envKey(reflect.TypeOf(cfg), parseErr.Name)
+				failed[key] = true
+				err = fmt.Errorf("%s is invalid: %w", key, parseErr.Err)
+			}
+			errs = append(errs, err)
+		}
+	}
+	overrideFields(reflect.ValueOf(&cfg).Elem(), reflect.ValueOf(fromEnv), environment, failed)
+	return cfg, errors.Join(append(errs, cfg.Validate())...)
+}
+
+// Environ returns the variables of the process environment.
+func Environ() map[string]string {
+	environment := map[string]string{}
+	for _, variable := range os.Environ() {
+		if key, value, ok := strings.Cut(variable, "="); ok {
+			environment[key] = value
+		}
+	}
+	return environment
+}
+
+// readFile decodes a YAML file into cfg, JSON is read as YAML. Unknown settings are rejected.
+func readFile(file string, cfg *Config) error {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("failed to read configuration file: %w", err)
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse configuration file %s: %w", file, err)
+	}
+	return nil
+}
+
+// overrideFields copies the fields whose variable is set in the environment from src to dst,
+// except for the ones that failed to parse.
+func overrideFields(dst, src reflect.Value, environment map[string]string, failed map[string]bool) {
+	for i := 0; i < dst.NumField(); i++ {
+		key, ok := dst.Type().Field(i).Tag.Lookup("env")
+		if !ok {
+			if dst.Field(i).Kind() == reflect.Struct {
+				overrideFields(dst.Field(i), src.Field(i), environment, failed)
+			}
+			continue
+		}
+		// an empty variable is the same as an unset one to env, which applies the default then
+		key = strings.Split(key, ",")[0]
+		if environment[key] != "" && !failed[key] {
+			dst.Field(i).Set(src.Field(i))
+		}
+	}
+}
+
+// envKey returns the variable of the named field, which may be nested in the provider settings.
+func envKey(t reflect.Type, name string) string {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key, ok := field.Tag.Lookup("env")
+		switch {
+		case ok && field.Name == name:
+			return strings.Split(key, ",")[0]
+		case !ok && field.Type.Kind() == reflect.Struct:
+			if key := envKey(field.Type, name); key != "" {
+				return key
+			}
+		}
+	}
+	return ""
+}
+
+// Validate checks all settings and reports every invalid one.
+func (c Config) Validate() error {
+	var errs []error
+	check := func(valid bool, format string, args ...interface{}) {
+		if !valid {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	check(c.ServerPort > 0 && c.ServerPort <= 65535, "SERVER_PORT must be between 1 and 65535, got %d", c.ServerPort)
+	check(c.MetricsPort >= 0 && c.MetricsPort <= 65535, "METRICS_PORT must be between 0 and 65535, got %d", c.MetricsPort)
+	check(c.MetricsPort == 0 || c.MetricsPort != c.ServerPort, "METRICS_PORT must differ from SERVER_PORT")
+	check(c.ServerReadTimeout >= 0, "SERVER_READ_TIMEOUT must not be negative")
+	check(c.ServerWriteTimeout >= 0, "SERVER_WRITE_TIMEOUT must not be negative")
+	check((c.ServerTLSCertFile == "") == (c.ServerTLSKeyFile == ""), "SERVER_TLS_CERT_FILE and SERVER_TLS_KEY_FILE have to be set together")
+	check(c.ServerTLSReloadInterval >= 0, "SERVER_TLS_RELOAD_INTERVAL must not be negative")
+	check(c.AuthToken == "" || c.AuthTokenFile == "", "only one of AUTH_TOKEN and AUTH_TOKEN_FILE can be set")
+	check(c.AuthClientCAFile == "" || c.ServerTLSCertFile != "", "AUTH_CLIENT_CA_FILE requires SERVER_TLS_CERT_FILE and SERVER_TLS_KEY_FILE")
+	if _, err := regexp.Compile(c.RegexDomainFilter); err != nil {
+		errs = append(errs, fmt.Errorf("REGEXP_DOMAIN_FILTER is invalid: %w", err))
+	}
+	if _, err := regexp.Compile(c.RegexDomainExclusion); err != nil {
+		errs = append(errs, fmt.Errorf("REGEXP_DOMAIN_FILTER_EXCLUSION is invalid: %w", err))
+	}
+	switch c.TracingExporter {
+	case "", tracing.ExporterNone, tracing.ExporterOTLP, tracing.ExporterStdout:
+	default:
+		errs = append(errs, fmt.Errorf("TRACING_EXPORTER must be one of '%s', '%s' and '%s', got '%s'",
+			tracing.ExporterNone, tracing.ExporterOTLP, tracing.ExporterStdout, c.TracingExporter))
+	}
+	check(c.TracingSampleRatio >= 0 && c.TracingSampleRatio <= 1, "TRACING_SAMPLE_RATIO must be between 0 and 1, got %v", c.TracingSampleRatio)
+	if _, err := ParseLogLevel(c.LogLevel); err != nil {
+		errs = append(errs, fmt.Errorf("LOG_LEVEL is invalid: %w", err))
+	}
+	check(c.LogFormat == "" || c.LogFormat == "text" || c.LogFormat == "json", "LOG_FORMAT must be 'text' or 'json', got '%s'", c.LogFormat)
+	if err := c.Provider.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// ParseLogLevel parses a logrus level name or number, the empty level is info.
+func ParseLogLevel(level string) (log.Level, error) {
+	if level == "" {
+		return log.InfoLevel, nil
+	}
+	if levelInt, err := strconv.Atoi(level); err == nil {
+		if levelInt < int(log.PanicLevel) || levelInt > int(log.TraceLevel) {
+			return 0, fmt.Errorf("level %d is out of range", levelInt)
+		}
+		return log.Level(uint32(levelInt)), nil
+	}
+	return log.ParseLevel(level)
+}
@@ -0,0 +1,114 @@
+package configuration
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bizflycloud/external-dns-bizflycloud-webhook/internal/bizflycloud"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	file := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(file, []byte(content), 0o600))
+	return file
+}
+
+func TestLoad(t *testing.T) {
+	file := writeFile(t, "config.yaml", `
+serverPort: 9000
+serverReadTimeout: 10s
+domainFilter: [example.com, example.org]
+logLevel: debug
+provider:
+  region: HCM
+  retryMaxAttempts: 2
+  accounts:
+    - name: prod
+      credentialId: id
+      credentialSecret: secret
+`)
+	config, err := Load(file, map[string]string{
+		"SERVER_PORT": "9001",
+		"BFC_REGION":  "HN",
+		// empty variables are unset
+		"LOG_LEVEL": "",
+	})
+	require.NoError(t, err)
+
+	// environment variables override the file, which overrides the defaults
+	assert.Equal(t, 9001, config.ServerPort)
+	assert.Equal(t, "localhost", config.ServerHost)
+	assert.Equal(t, 10*time.Second, config.ServerReadTimeout)
+	assert.Equal(t, []string{"example.com", "example.org"}, config.DomainFilter)
+	assert.Equal(t, "debug", config.LogLevel)
+	assert.Equal(t, "HN", config.Provider.Region)
+	assert.Equal(t, 2, config.Provider.RetryMaxAttempts)
+	assert.Equal(t, 100, config.Provider.APIPageSize)
+	assert.Equal(t, bizflycloud.Accounts{{Name: "prod", CredentialID: "id", CredentialSecret: "secret"}}, config.Provider.Accounts)
+}
+
+func TestLoadJSON(t *testing.T) {
+	file := writeFile(t, "config.json", `{"serverHost": "0.0.0.0", "provider": {"credentialId": "id", "credentialSecret": "secret"}}`)
+	config, err := Load(file, map[string]string{})
+	require.NoError(t, err)
+	assert.Equal(t, "0.0.0.0", config.ServerHost)
+	assert.Equal(t, "id", config.Provider.APICredentialId)
+}
+
+func TestLoadWithoutFile(t *testing.T) {
+	config, err := Load("", map[string]string{"BFC_APP_CREDENTIAL_ID": "id", "BFC_APP_CREDENTIAL_SECRET": "secret"})
+	require.NoError(t, err)
+	assert.Equal(t, 8888, config.ServerPort)
+	assert.Equal(t, "secret", config.Provider.APICredentialSecret)
+}
+
+func TestLoadRejectsUnknownSettings(t *testing.T) {
+	file := writeFile(t, "config.yaml", "serverPort: 9000\nserverPrt: 9001\n")
+	_, err := Load(file, map[string]string{})
+	assert.ErrorContains(t, err, "field serverPrt not found")
+}
+
+func TestLoadReportsAllErrors(t *testing.T) {
+	file := writeFile(t, "config.yaml", `
+serverPort: 70000
+serverHost: [localhost]
+regexpDomainFilter: "("
+tracingExporter: jaeger
+provider:
+  apiPageSize: 0
+  zoneStateFilter: [gone]
+`)
+	_, err := Load(file, map[string]string{"METRICS_PORT": "many", "BFC_CACHE_TTL": "soon", "LOG_FORMAT": "xml"})
+	require.Error(t, err)
+	for _, message := range []string{
+		`METRICS_PORT is invalid: strconv.ParseInt: parsing "many"`,
+		`BFC_CACHE_TTL is invalid: unable to parse duration: time: invalid duration "soon"`,
+		"cannot unmarshal !!seq into string",
+		"SERVER_PORT must be between 1 and 65535, got 70000",
+		"REGEXP_DOMAIN_FILTER is invalid",
+		"TRACING_EXPORTER must be one of",
+		"LOG_FORMAT must be 'text' or 'json', got 'xml'",
+		"BFC_API_PAGE_SIZE must be positive, got 0",
+		"BFC_ZONE_STATE_FILTER is invalid: unknown zone state 'gone'",
+		"BFC_APP_CREDENTIAL_ID and BFC_APP_CREDENTIAL_SECRET or BFC_ACCOUNTS are required",
+	} {
+		assert.ErrorContains(t, err, message)
+	}
+}
+
+func TestParseLogLevel(t *testing.T) {
+	for level, expected := range map[string]string{"": "info", "warn": "warning", "5": "debug"} {
+		parsed, err := ParseLogLevel(level)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, parsed.String())
+	}
+	for _, level := range []string{"verbose", "7", "-1"} {
+		_, err := ParseLogLevel(level)
+		assert.Error(t, err, level)
+	}
+}
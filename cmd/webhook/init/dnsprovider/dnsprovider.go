@@ -5,8 +5,6 @@ import (
 	"regexp"
 	"strings"
 
-	"github.com/caarlos0/env/v8"
-
 	"github.com/bizflycloud/external-dns-bizflycloud-webhook/cmd/webhook/init/configuration"
 	"github.com/bizflycloud/external-dns-bizflycloud-webhook/internal/bizflycloud"
 	"github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/endpoint"
@@ -15,36 +13,52 @@ import (
 )
 
 func Init(config configuration.Config) (provider.Provider, error) {
-	var domainFilter endpoint.DomainFilter
-	createMsg := "Creating BIZFLYCLOUD provider with "
+	domainFilter, zoneIDFilter, err := Filters(config)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("Creating BIZFLYCLOUD provider with " + DescribeFilters(config))
+	return bizflycloud.NewBizflyCloudProvider(domainFilter, zoneIDFilter, &config.Provider)
+}
 
+// Filters compiles the domain and zone ID filters of the configuration.
+func Filters(config configuration.Config) (endpoint.DomainFilter, provider.ZoneIDFilter, error) {
+	zoneIDFilter := provider.NewZoneIDFilter(config.ZoneIDFilter)
+	if config.RegexDomainFilter == "" {
+		return endpoint.NewDomainFilterWithExclusions(config.DomainFilter, config.ExcludeDomains), zoneIDFilter, nil
+	}
+	regexDomainFilter, err := regexp.Compile(config.RegexDomainFilter)
+	if err != nil {
+		return endpoint.DomainFilter{}, zoneIDFilter, fmt.Errorf("invalid REGEXP_DOMAIN_FILTER: %w", err)
+	}
+	regexDomainExclusion, err := regexp.Compile(config.RegexDomainExclusion)
+	if err != nil {
+		return endpoint.DomainFilter{}, zoneIDFilter, fmt.Errorf("invalid REGEXP_DOMAIN_FILTER_EXCLUSION: %w", err)
+	}
+	return endpoint.NewRegexDomainFilter(regexDomainFilter, regexDomainExclusion), zoneIDFilter, nil
+}
+
+// DescribeFilters describes the domain and zone ID filters of the configuration.
+func DescribeFilters(config configuration.Config) string {
+	description := ""
 	if config.RegexDomainFilter != "" {
-		createMsg += fmt.Sprintf("Regexp domain filter: '%s', ", config.RegexDomainFilter)
+		description += fmt.Sprintf("Regexp domain filter: '%s', ", config.RegexDomainFilter)
 		if config.RegexDomainExclusion != "" {
-			createMsg += fmt.Sprintf("with exclusion: '%s', ", config.RegexDomainExclusion)
+			description += fmt.Sprintf("with exclusion: '%s', ", config.RegexDomainExclusion)
 		}
-		domainFilter = endpoint.NewRegexDomainFilter(
-			regexp.MustCompile(config.RegexDomainFilter),
-			regexp.MustCompile(config.RegexDomainExclusion),
-		)
 	} else {
-		if config.DomainFilter != nil && len(config.DomainFilter) > 0 {
-			createMsg += fmt.Sprintf("zoneNode filter: '%s', ", strings.Join(config.DomainFilter, ","))
+		if len(config.DomainFilter) > 0 {
+			description += fmt.Sprintf("zoneNode filter: '%s', ", strings.Join(config.DomainFilter, ","))
 		}
-		if config.ExcludeDomains != nil && len(config.ExcludeDomains) > 0 {
-			createMsg += fmt.Sprintf("Exclude domain filter: '%s', ", strings.Join(config.ExcludeDomains, ","))
+		if len(config.ExcludeDomains) > 0 {
+			description += fmt.Sprintf("Exclude domain filter: '%s', ", strings.Join(config.ExcludeDomains, ","))
 		}
-		domainFilter = endpoint.NewDomainFilterWithExclusions(config.DomainFilter, config.ExcludeDomains)
 	}
-
-	createMsg = strings.TrimSuffix(createMsg, ", ")
-	if strings.HasSuffix(createMsg, "with ") {
-		createMsg += "no kind of domain filters"
+	if zoneIDFilter := provider.NewZoneIDFilter(config.ZoneIDFilter); zoneIDFilter.IsConfigured() {
+		description += fmt.Sprintf("zone ID filter: '%s', ", strings.Join(zoneIDFilter.ZoneIDs, ","))
 	}
-	log.Info(createMsg)
-	bizflycloudConfig := bizflycloud.Configuration{}
-	if err := env.Parse(&bizflycloudConfig); err != nil {
-		return nil, fmt.Errorf("reading bizflycloudConfig failed: %v", err)
+	if description == "" {
+		return "no kind of domain filters"
 	}
-	return bizflycloud.NewBizflyCloudProvider(domainFilter, &bizflycloudConfig)
+	return strings.TrimSuffix(description, ", ")
 }
@@ -12,10 +12,10 @@ import (
 func TestInit(t *testing.T) {
 	log.SetLevel(log.DebugLevel)
 
-	config := configuration.Config{}
 	_ = os.Setenv("BFC_APP_CREDENTIAL_ID", "e5d084f79fd5407da705f8df97332090")
 	_ = os.Setenv("BFC_APP_CREDENTIAL_SECRET", "Fhkp66ClGHPFTnjHQId1RWrUoG14qMIK8IWT4GxURTNLfY2cAkVmpTwyJ-xYsDlEqrS0lqBSGG8DEGZma6OQgQ")
 
+	config, _ := configuration.Load("", configuration.Environ())
 	dnsProvider, err := Init(config)
 	assert.NotNil(t, dnsProvider)
 	if err != nil {
@@ -23,6 +23,7 @@ func TestInit(t *testing.T) {
 	}
 
 	_ = os.Setenv("DOMAIN_FILTER", "vietquocxa.online")
+	config, _ = configuration.Load("", configuration.Environ())
 	dnsProvider, err = Init(config)
 	assert.NotNil(t, dnsProvider)
 	if err != nil {
@@ -32,8 +33,38 @@ func TestInit(t *testing.T) {
 	_ = os.Unsetenv("BFC_APP_CREDENTIAL_ID")
 	_ = os.Unsetenv("BFC_APP_CREDENTIAL_SECRET")
 	_ = os.Unsetenv("DOMAIN_FILTER")
+	config, _ = configuration.Load("", configuration.Environ())
 	_, err = Init(config)
 	if err == nil {
 		t.Errorf("expected to fail")
 	}
 }
+
+func TestFilters(t *testing.T) {
+	domainFilter, zoneIDFilter, err := Filters(configuration.Config{
+		RegexDomainFilter:    `(^|\.)example\.com$`,
+		RegexDomainExclusion: `^internal\.`,
+		ZoneIDFilter:         []string{"Z001"},
+	})
+	assert.NoError(t, err)
+	assert.True(t, domainFilter.Match("www.example.com"))
+	assert.False(t, domainFilter.Match("internal.example.com"))
+	assert.True(t, zoneIDFilter.Match("Z001"))
+	assert.False(t, zoneIDFilter.Match("Z002"))
+
+	// an invalid expression is an error instead of a panic
+	_, _, err = Filters(configuration.Config{RegexDomainFilter: "("})
+	assert.ErrorContains(t, err, "invalid REGEXP_DOMAIN_FILTER")
+	_, _, err = Filters(configuration.Config{RegexDomainFilter: "a", RegexDomainExclusion: "["})
+	assert.ErrorContains(t, err, "invalid REGEXP_DOMAIN_FILTER_EXCLUSION")
+}
+
+func TestDescribeFilters(t *testing.T) {
+	assert.Equal(t, "no kind of domain filters", DescribeFilters(configuration.Config{}))
+	assert.Equal(t, "zoneNode filter: 'example.com', Exclude domain filter: 'internal.example.com', zone ID filter: 'Z001'",
+		DescribeFilters(configuration.Config{
+			DomainFilter:   []string{"example.com"},
+			ExcludeDomains: []string{"internal.example.com"},
+			ZoneIDFilter:   []string{"Z001"},
+		}))
+}
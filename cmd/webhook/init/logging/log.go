@@ -1,19 +1,17 @@
 package logging
 
 import (
-	"os"
-	"strconv"
-
 	log "github.com/sirupsen/logrus"
+
+	"github.com/bizflycloud/external-dns-bizflycloud-webhook/cmd/webhook/init/configuration"
 )
 
-func Init() {
-	setLogLevel()
-	setLogFormat()
+func Init(config configuration.Config) {
+	setLogLevel(config.LogLevel)
+	setLogFormat(config.LogFormat)
 }
 
-func setLogFormat() {
-	format := os.Getenv("LOG_FORMAT")
+func setLogFormat(format string) {
 	if format == "json" {
 		log.SetFormatter(&log.JSONFormatter{})
 	} else {
@@ -21,21 +19,12 @@ func setLogFormat() {
 	}
 }
 
-func setLogLevel() {
-	level := os.Getenv("LOG_LEVEL")
-	if level == "" {
+func setLogLevel(level string) {
+	levelInt, err := configuration.ParseLogLevel(level)
+	if err != nil {
 		log.SetLevel(log.InfoLevel)
-	} else {
-		if levelInt, err := strconv.Atoi(level); err == nil {
-			log.SetLevel(log.Level(uint32(levelInt)))
-		} else {
-			levelInt, err := log.ParseLevel(level)
-			if err != nil {
-				log.SetLevel(log.InfoLevel)
-				log.Errorf("Invalid log level '%s', defaulting to info", level)
-			} else {
-				log.SetLevel(levelInt)
-			}
-		}
+		log.Errorf("Invalid log level '%s', defaulting to info", level)
+		return
 	}
+	log.SetLevel(levelInt)
 }
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/bizflycloud/external-dns-bizflycloud-webhook/cmd/webhook/init/configuration"
+	"github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/requestid"
+)
+
+// unauthenticatedPaths are served without authentication, so that probes keep working
+var unauthenticatedPaths = map[string]bool{
+	"/health":  true,
+	"/healthz": true,
+	"/readyz":  true,
+}
+
+// authenticator accepts requests carrying the shared bearer token or a verified client certificate
+type authenticator struct {
+	token      []byte
+	clientCert bool
+}
+
+// newAuthenticator returns nil if no authentication is configured
+func newAuthenticator(config configuration.Config) (*authenticator, error) {
+	if config.AuthToken != "" && config.AuthTokenFile != "" {
+		return nil, fmt.Errorf("only one of AUTH_TOKEN and AUTH_TOKEN_FILE can be set")
+	}
+	token := config.AuthToken
+	if config.AuthTokenFile != "" {
+		content, err := os.ReadFile(config.AuthTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read auth token: %w", err)
+		}
+		token = strings.TrimSpace(string(content))
+		if token == "" {
+			return nil, fmt.Errorf("auth token file %s is empty", config.AuthTokenFile)
+		}
+	}
+	if token == "" && config.AuthClientCAFile == "" {
+		return nil, nil
+	}
+	return &authenticator{token: []byte(token), clientCert: config.AuthClientCAFile != ""}, nil
+}
+
+// Middleware rejects unauthenticated requests with 401
+func (a *authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if unauthenticatedPaths[r.URL.Path] || a.authenticated(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		requestid.Logger(r.Context()).WithFields(log.Fields{"requestMethod": r.Method, "requestPath": r.URL.Path, "remoteAddr": r.RemoteAddr}).Warn("rejecting unauthenticated request")
+		if len(a.token) > 0 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="external-dns-bizflycloud-webhook"`)
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = fmt.Fprint(w, "unauthorized")
+	})
+}
+
+func (a *authenticator) authenticated(r *http.Request) bool {
+	// the certificate was verified against the client CA during the handshake
+	if a.clientCert && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return true
+	}
+	if len(a.token) == 0 {
+		return false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), a.token) == 1
+}
@@ -0,0 +1,222 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+
+	"github.com/bizflycloud/external-dns-bizflycloud-webhook/cmd/webhook/init/configuration"
+	"github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/requestid"
+	"github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/webhook"
+)
+
+// testCA issues certificates for the TLS tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns a PEM encoded certificate and key for a server or client
+func (ca *testCA) issue(t *testing.T, name string, usage x509.ExtKeyUsage) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeFile(t *testing.T, name string, content []byte) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func statusOf(t *testing.T, client *http.Client, url string, headers map[string]string) int {
+	request, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("Accept", "application/external.dns.webhook+json;version=1")
+	for k, v := range headers {
+		request.Header.Set(k, v)
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = response.Body.Close()
+	return response.StatusCode
+}
+
+func TestBearerTokenAuthentication(t *testing.T) {
+	mockProvider.testCase = testCase{}
+	config := defaultConfig()
+	config.ServerPort = 8889
+	config.AuthTokenFile = writeFile(t, "token", []byte("s3cr3t\n"))
+	srv := Init(config, webhook.New(mockProvider))
+	defer func() { _ = srv.Shutdown(context.Background()) }()
+	time.Sleep(100 * time.Millisecond)
+
+	for _, tc := range []struct {
+		path     string
+		headers  map[string]string
+		expected int
+	}{
+		{path: "/records", expected: http.StatusUnauthorized},
+		{path: "/records", headers: map[string]string{"Authorization": "Bearer wrong"}, expected: http.StatusUnauthorized},
+		{path: "/records", headers: map[string]string{"Authorization": "s3cr3t"}, expected: http.StatusUnauthorized},
+		{path: "/records", headers: map[string]string{"Authorization": "Bearer s3cr3t"}, expected: http.StatusOK},
+		{path: "/metrics", expected: http.StatusUnauthorized},
+		{path: "/health", expected: http.StatusOK},
+		{path: "/healthz", expected: http.StatusOK},
+		{path: "/readyz", expected: http.StatusOK},
+	} {
+		if status := statusOf(t, http.DefaultClient, "http://localhost:8889"+tc.path, tc.headers); status != tc.expected {
+			t.Errorf("%s with headers %v: expected status code %d, got %d", tc.path, tc.headers, tc.expected, status)
+		}
+	}
+}
+
+func TestAuthenticationLogsRequestID(t *testing.T) {
+	hook := logtest.NewGlobal()
+	defer hook.Reset()
+	auth := &authenticator{token: []byte("s3cr3t")}
+	handler := requestid.Middleware(auth.Middleware(http.NotFoundHandler()))
+
+	request := httptest.NewRequest(http.MethodGet, "/records", nil)
+	request.Header.Set(requestid.Header, "req-1")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status code %d, got %d", http.StatusUnauthorized, recorder.Code)
+	}
+	entry := hook.LastEntry()
+	if entry == nil || entry.Level != log.WarnLevel || entry.Data[requestid.LogField] != "req-1" {
+		t.Errorf("expected the rejection to be logged with the request ID, got %v", entry)
+	}
+}
+
+func TestClientCertificateAuthentication(t *testing.T) {
+	ca := newTestCA(t)
+	serverCert, serverKey := ca.issue(t, "localhost", x509.ExtKeyUsageServerAuth)
+	clientCertPEM, clientKeyPEM := ca.issue(t, "external-dns", x509.ExtKeyUsageClientAuth)
+	clientCert, err := tls.X509KeyPair(clientCertPEM, clientKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherCertPEM, otherKeyPEM := newTestCA(t).issue(t, "intruder", x509.ExtKeyUsageClientAuth)
+	otherCert, err := tls.X509KeyPair(otherCertPEM, otherKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mockProvider.testCase = testCase{}
+	config := defaultConfig()
+	config.ServerPort = 8890
+	config.ServerTLSCertFile = writeFile(t, "tls.crt", serverCert)
+	config.ServerTLSKeyFile = writeFile(t, "tls.key", serverKey)
+	config.AuthClientCAFile = writeFile(t, "ca.crt", ca.pem)
+	srv := Init(config, webhook.New(mockProvider))
+	defer func() { _ = srv.Shutdown(context.Background()) }()
+	time.Sleep(100 * time.Millisecond)
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(ca.pem)
+	clientWith := func(certs ...tls.Certificate) *http.Client {
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs}}}
+	}
+
+	if status := statusOf(t, clientWith(clientCert), "https://localhost:8890/records", nil); status != http.StatusOK {
+		t.Errorf("expected status code %d with a client certificate, got %d", http.StatusOK, status)
+	}
+	if status := statusOf(t, clientWith(), "https://localhost:8890/records", nil); status != http.StatusUnauthorized {
+		t.Errorf("expected status code %d without a client certificate, got %d", http.StatusUnauthorized, status)
+	}
+	if status := statusOf(t, clientWith(), "https://localhost:8890/healthz", nil); status != http.StatusOK {
+		t.Errorf("expected status code %d for probes without a client certificate, got %d", http.StatusOK, status)
+	}
+	// certificates of other CAs fail the handshake
+	request, _ := http.NewRequest(http.MethodGet, "https://localhost:8890/records", nil)
+	if _, err := clientWith(otherCert).Do(request); err == nil {
+		t.Errorf("expected the handshake to fail for a certificate of another CA")
+	}
+}
+
+func TestAuthenticationConfiguration(t *testing.T) {
+	for name, config := range map[string]configuration.Config{
+		"token and token file": {AuthToken: "a", AuthTokenFile: "b"},
+		"missing token file":   {AuthTokenFile: filepath.Join(t.TempDir(), "missing")},
+		"empty token file":     {AuthTokenFile: writeFile(t, "token", []byte("\n"))},
+	} {
+		if _, err := newAuthenticator(config); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	if auth, err := newAuthenticator(configuration.Config{}); auth != nil || err != nil {
+		t.Errorf("expected no authentication by default, got %v, %v", auth, err)
+	}
+}
@@ -15,32 +15,88 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	"github.com/bizflycloud/external-dns-bizflycloud-webhook/cmd/webhook/init/configuration"
+	"github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/metrics"
 
+	"github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/requestid"
 	"github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/webhook"
 )
 
 // Init server initialization function
 // The server will respond to the following endpoints:
+// - /healthz (GET): liveness probe
+// - /readyz (GET): readiness probe, fails while the provider can not reach Bizfly Cloud
 // - / (GET): initialization, negotiates headers and returns the domain filter
 // - /records (GET): returns the current records
 // - /records (POST): applies the changes
+// - /records/plan (POST): returns the API operations the changes would result in, without applying them
 // - /adjustendpoints (POST): executes the AdjustEndpoints method
+// - /metrics (GET): Prometheus metrics, unless they are served on a separate port by InitMetrics
+//
+// Every request is identified by the X-Request-ID header sent by the caller, or a generated one. The ID is
+// echoed in the response and included in the logs of the request.
+//
+// When AUTH_TOKEN, AUTH_TOKEN_FILE or AUTH_CLIENT_CA_FILE is set, all endpoints but the probes require
+// the bearer token or a client certificate signed by the client CA.
 func Init(config configuration.Config, p *webhook.Webhook) *http.Server {
+	auth, err := newAuthenticator(config)
+	if err != nil {
+		log.Fatalf("Error setting up authentication: %v", err)
+	}
+	tlsConf, err := tlsConfig(config)
+	if err != nil {
+		log.Fatalf("Error setting up TLS: %v", err)
+	}
+
 	r := chi.NewRouter()
+	r.Use(requestid.Middleware)
 	r.Use(webhook.Health)
-	r.Get("/", p.Negotiate)
-	r.Get("/records", p.Records)
-	r.Post("/records", p.ApplyChanges)
-	r.Post("/adjustendpoints", p.AdjustEndpoints)
+	if auth != nil {
+		r.Use(auth.Middleware)
+	}
+	r.Get("/healthz", webhook.Liveness)
+	r.Get("/readyz", p.Readiness)
+	r.Get("/", metrics.InstrumentHandler("Negotiate", p.Negotiate))
+	r.Get("/records", metrics.InstrumentHandler("Records", p.Records))
+	r.Post("/records", metrics.InstrumentHandler("ApplyChanges", p.ApplyChanges))
+	r.Post("/records/plan", metrics.InstrumentHandler("PlanChanges", p.PlanChanges))
+	r.Post("/adjustendpoints", metrics.InstrumentHandler("AdjustEndpoints", p.AdjustEndpoints))
+	if config.MetricsPort == 0 {
+		r.Handle("/metrics", metrics.Handler())
+	}
 
 	srv := createHTTPServer(fmt.Sprintf("%s:%d", config.ServerHost, config.ServerPort), r, config.ServerReadTimeout, config.ServerWriteTimeout)
+	srv.TLSConfig = tlsConf
+	serve(srv)
+	return srv
+}
+
+// InitMetrics starts the server for /metrics if it is configured on a separate port, otherwise it returns nil
+func InitMetrics(config configuration.Config) *http.Server {
+	if config.MetricsPort == 0 {
+		return nil
+	}
+	r := chi.NewRouter()
+	r.Handle("/metrics", metrics.Handler())
+
+	srv := createHTTPServer(fmt.Sprintf("%s:%d", config.ServerHost, config.MetricsPort), r, config.ServerReadTimeout, config.ServerWriteTimeout)
+	serve(srv)
+	return srv
+}
+
+func serve(srv *http.Server) {
 	go func() {
 		log.Infof("starting server on addr: '%s' ", srv.Addr)
-		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		var err error
+		if srv.TLSConfig != nil {
+			// the certificates are part of the TLS config
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			log.Errorf("can't serve on addr: '%s', error: %v", srv.Addr, err)
 		}
 	}()
-	return srv
 }
 
 func createHTTPServer(addr string, hand http.Handler, readTimeout, writeTimeout time.Duration) *http.Server {
@@ -52,15 +108,20 @@ func createHTTPServer(addr string, hand http.Handler, readTimeout, writeTimeout
 	}
 }
 
-// ShutdownGracefully gracefully shutdown the http server
-func ShutdownGracefully(srv *http.Server) {
+// ShutdownGracefully gracefully shutdown the http servers, nil servers are skipped
+func ShutdownGracefully(servers ...*http.Server) {
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 	sig := <-sigCh
 	log.Infof("shutting down server due to received signal: %v", sig)
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	if err := srv.Shutdown(ctx); err != nil {
-		log.Errorf("error shutting down server: %v", err)
+	for _, srv := range servers {
+		if srv == nil {
+			continue
+		}
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Errorf("error shutting down server: %v", err)
+		}
 	}
 	cancel()
 }
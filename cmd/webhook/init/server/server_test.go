@@ -14,8 +14,13 @@ import (
 
 	"github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/endpoint"
 	"github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/plan"
+	"github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/provider"
+	"github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/tracing"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 
 	"github.com/bizflycloud/external-dns-bizflycloud-webhook/cmd/webhook/init/configuration"
+	"github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/requestid"
 	"github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/webhook"
 )
 
@@ -24,6 +29,7 @@ type testCase struct {
 	returnRecords             []*endpoint.Endpoint
 	returnAdjustedEndpoints   []*endpoint.Endpoint
 	returnDomainFilter        endpoint.DomainFilter
+	returnPlan                *provider.ChangePlan
 	hasError                  error
 	method                    string
 	path                      string
@@ -41,7 +47,7 @@ var mockProvider *MockProvider
 
 func TestMain(m *testing.M) {
 	mockProvider = &MockProvider{}
-	srv := Init(configuration.Init(), webhook.New(mockProvider))
+	srv := Init(defaultConfig(), webhook.New(mockProvider))
 	go ShutdownGracefully(srv)
 	time.Sleep(300 * time.Millisecond)
 	m.Run()
@@ -50,6 +56,12 @@ func TestMain(m *testing.M) {
 	}
 }
 
+// defaultConfig returns the default configuration, the missing provider credentials do not matter to the server
+func defaultConfig() configuration.Config {
+	config, _ := configuration.Load("", map[string]string{})
+	return config
+}
+
 func TestRecords(t *testing.T) {
 	testCases := []testCase{
 		{
@@ -220,6 +232,82 @@ func TestApplyChanges(t *testing.T) {
 }`,
 			expectedStatusCode: http.StatusInternalServerError,
 		},
+		{
+			name: "failed changes",
+			hasError: &provider.ApplyChangesError{Errors: []*provider.RecordError{
+				provider.NewRecordError("Z001", "test.example.com", "A", "", "CREATE", fmt.Errorf("quota exceeded")),
+			}},
+			method: http.MethodPost,
+			headers: map[string]string{
+				"Content-Type": "application/external.dns.webhook+json;version=1",
+			},
+			path:               "/records",
+			body:               `{"Create": [{"dnsName": "test.example.com", "targets": ["11.11.11.11"], "recordType": "A"}]}`,
+			expectedStatusCode: http.StatusInternalServerError,
+			expectedResponseHeaders: map[string]string{
+				"Content-Type": "application/json",
+			},
+			expectedBody: `{"errors":[{"zone":"Z001","record":"test.example.com","type":"A","action":"CREATE","reason":"quota exceeded"}]}`,
+		},
+	}
+	executeTestCases(t, testCases)
+}
+
+func TestPlanChanges(t *testing.T) {
+	testCases := []testCase{
+		{
+			name:   "happy case",
+			method: http.MethodPost,
+			headers: map[string]string{
+				"Content-Type": "application/external.dns.webhook+json;version=1",
+			},
+			path: "/records/plan",
+			body: `{"Create": [{"dnsName": "test.example.com", "targets": ["11.11.11.11"], "recordType": "A"}]}`,
+			returnPlan: &provider.ChangePlan{
+				Zones: []*provider.ZonePlan{{ID: "Z001", Name: "example.com", Operations: []*provider.PlannedOperation{
+					{Action: "CREATE", ChangeAction: "CREATE", Record: "test.example.com", Type: "A", Payload: map[string]string{"name": "test"}},
+				}}},
+				Skipped: []*provider.RecordError{},
+			},
+			expectedStatusCode: http.StatusOK,
+			expectedResponseHeaders: map[string]string{
+				"Content-Type": "application/json",
+			},
+			expectedBody: `{"zones":[{"id":"Z001","name":"example.com","operations":[{"action":"CREATE","changeAction":"CREATE","record":"test.example.com","type":"A","payload":{"name":"test"}}]}],"skipped":[]}`,
+			expectedChanges: &plan.Changes{
+				Create: []*endpoint.Endpoint{{DNSName: "test.example.com", Targets: []string{"11.11.11.11"}, RecordType: "A"}},
+			},
+		},
+		{
+			name:               "no content type header",
+			method:             http.MethodPost,
+			path:               "/records/plan",
+			expectedStatusCode: http.StatusNotAcceptable,
+			expectedBody:       "client must provide a content type",
+		},
+		{
+			name:   "invalid json",
+			method: http.MethodPost,
+			headers: map[string]string{
+				"Content-Type": "application/external.dns.webhook+json;version=1",
+			},
+			path:               "/records/plan",
+			body:               "invalid",
+			expectedStatusCode: http.StatusBadRequest,
+			expectedBody:       "error decoding changes: invalid character 'i' looking for beginning of value",
+		},
+		{
+			name:     "backend error",
+			hasError: fmt.Errorf("failed to list zones"),
+			method:   http.MethodPost,
+			headers: map[string]string{
+				"Content-Type": "application/external.dns.webhook+json;version=1",
+			},
+			path:               "/records/plan",
+			body:               `{}`,
+			expectedStatusCode: http.StatusInternalServerError,
+			expectedBody:       "failed to list zones",
+		},
 	}
 	executeTestCases(t, testCases)
 }
@@ -396,6 +484,150 @@ func TestNegotiate(t *testing.T) {
 	executeTestCases(t, testCases)
 }
 
+func TestProbes(t *testing.T) {
+	testCases := []testCase{
+		{
+			name:               "alive",
+			method:             http.MethodGet,
+			path:               "/healthz",
+			expectedStatusCode: http.StatusOK,
+			expectedBody:       "ok",
+		},
+		{
+			name:               "alive while the provider is not ready",
+			hasError:           fmt.Errorf("failed to list zones"),
+			method:             http.MethodGet,
+			path:               "/healthz",
+			expectedStatusCode: http.StatusOK,
+			expectedBody:       "ok",
+		},
+		{
+			name:               "ready",
+			method:             http.MethodGet,
+			path:               "/readyz",
+			expectedStatusCode: http.StatusOK,
+			expectedBody:       "ok",
+		},
+		{
+			name:               "not ready",
+			hasError:           fmt.Errorf("failed to list zones"),
+			method:             http.MethodGet,
+			path:               "/readyz",
+			expectedStatusCode: http.StatusServiceUnavailable,
+			expectedResponseHeaders: map[string]string{
+				"Content-Type": "text/plain",
+			},
+			expectedBody: "failed to list zones",
+		},
+	}
+	executeTestCases(t, testCases)
+}
+
+func TestTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := tracing.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer func() { _ = provider.Shutdown(context.Background()) }()
+
+	mockProvider.testCase = testCase{}
+	request, err := http.NewRequest(http.MethodGet, "http://localhost:8888/records", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("Accept", "application/external.dns.webhook+json;version=1")
+	request.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = response.Body.Close()
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 span, got %d", len(spans))
+	}
+	if spans[0].Name != "webhook.Records" {
+		t.Errorf("expected span 'webhook.Records', got '%s'", spans[0].Name)
+	}
+	if traceID := spans[0].SpanContext.TraceID().String(); traceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("expected the trace of the caller to be continued, got trace '%s'", traceID)
+	}
+	if parentID := spans[0].Parent.SpanID().String(); parentID != "00f067aa0ba902b7" {
+		t.Errorf("expected the span of the caller as parent, got '%s'", parentID)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	mockProvider.testCase = testCase{}
+	get := func(headers map[string]string) string {
+		request, err := http.NewRequest(http.MethodGet, "http://localhost:8888/records", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request.Header.Set("Accept", "application/external.dns.webhook+json;version=1")
+		for k, v := range headers {
+			request.Header.Set(k, v)
+		}
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = response.Body.Close()
+		return response.Header.Get("X-Request-ID")
+	}
+
+	if id := get(map[string]string{"X-Request-ID": "sync-42"}); id != "sync-42" {
+		t.Errorf("expected the request ID sent by the caller to be echoed, got '%s'", id)
+	}
+	if mockProvider.requestID != "sync-42" {
+		t.Errorf("expected the provider to be called with request ID 'sync-42', got '%s'", mockProvider.requestID)
+	}
+	generated := get(nil)
+	if len(generated) != 32 {
+		t.Errorf("expected a generated request ID, got '%s'", generated)
+	}
+	if mockProvider.requestID != generated {
+		t.Errorf("expected the provider to be called with the generated request ID '%s', got '%s'", generated, mockProvider.requestID)
+	}
+	if id := get(map[string]string{"X-Request-ID": "with spaces"}); id == "with spaces" || len(id) != 32 {
+		t.Errorf("expected an invalid request ID to be replaced, got '%s'", id)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	mockProvider.testCase = testCase{}
+	request, err := http.NewRequest(http.MethodGet, "http://localhost:8888/records", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("Accept", "application/external.dns.webhook+json;version=1")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = response.Body.Close()
+
+	response, err = http.Get("http://localhost:8888/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("expected status code %d, got %d", http.StatusOK, response.StatusCode)
+	}
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, metric := range []string{
+		`bizflycloud_webhook_http_requests_total{code="200",handler="Records"}`,
+		`bizflycloud_webhook_http_request_duration_seconds_count{handler="Records"}`,
+	} {
+		if !strings.Contains(string(body), metric) {
+			t.Errorf("expected metric '%s' in response", metric)
+		}
+	}
+}
+
 func executeTestCases(t *testing.T, testCases []testCase) {
 	log.SetLevel(log.DebugLevel)
 	for i, tc := range testCases {
@@ -443,10 +675,13 @@ func executeTestCases(t *testing.T, testCases []testCase) {
 type MockProvider struct {
 	t        *testing.T
 	testCase testCase
+	// requestID is the request ID the provider was last called with
+	requestID string
 }
 
 // Records MockProvider implementation to be removed when real providers are added
 func (d *MockProvider) Records(ctx context.Context) ([]*endpoint.Endpoint, error) {
+	d.requestID = requestid.FromContext(ctx)
 	return d.testCase.returnRecords, d.testCase.hasError
 }
 
@@ -461,6 +696,16 @@ func (d *MockProvider) ApplyChanges(ctx context.Context, changes *plan.Changes)
 	return nil
 }
 
+func (d *MockProvider) PlanChanges(ctx context.Context, changes *plan.Changes) (*provider.ChangePlan, error) {
+	if d.testCase.hasError != nil {
+		return nil, d.testCase.hasError
+	}
+	if !reflect.DeepEqual(changes, d.testCase.expectedChanges) {
+		d.t.Errorf("expected changes '%v', got '%v'", d.testCase.expectedChanges, changes)
+	}
+	return d.testCase.returnPlan, nil
+}
+
 func (d *MockProvider) AdjustEndpoints(endpoints []*endpoint.Endpoint) []*endpoint.Endpoint {
 	if !reflect.DeepEqual(endpoints, d.testCase.expectedEndpointsToAdjust) {
 		d.t.Errorf("expected endpoints to adjust '%v', got '%v'", d.testCase.expectedEndpointsToAdjust, endpoints)
@@ -471,3 +716,7 @@ func (d *MockProvider) AdjustEndpoints(endpoints []*endpoint.Endpoint) []*endpoi
 func (d *MockProvider) GetDomainFilter() endpoint.DomainFilter {
 	return d.testCase.returnDomainFilter
 }
+
+func (d *MockProvider) Ready(ctx context.Context) error {
+	return d.testCase.hasError
+}
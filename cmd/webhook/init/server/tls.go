@@ -0,0 +1,116 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/bizflycloud/external-dns-bizflycloud-webhook/cmd/webhook/init/configuration"
+	"github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/filewatch"
+)
+
+// tlsConfig returns the TLS configuration of the webhook server, or nil if it serves plain HTTP
+func tlsConfig(config configuration.Config) (*tls.Config, error) {
+	if config.ServerTLSCertFile == "" && config.ServerTLSKeyFile == "" {
+		if config.AuthClientCAFile != "" {
+			return nil, fmt.Errorf("AUTH_CLIENT_CA_FILE requires SERVER_TLS_CERT_FILE and SERVER_TLS_KEY_FILE")
+		}
+		return nil, nil
+	}
+	if config.ServerTLSCertFile == "" || config.ServerTLSKeyFile == "" {
+		return nil, fmt.Errorf("SERVER_TLS_CERT_FILE and SERVER_TLS_KEY_FILE have to be set together")
+	}
+	reloader, err := newCertReloader(config.ServerTLSCertFile, config.ServerTLSKeyFile, config.ServerTLSReloadInterval)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+	if config.AuthClientCAFile != "" {
+		pem, err := os.ReadFile(config.AuthClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in client CA file %s", config.AuthClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		// probes come without a client certificate, the middleware requires one for everything else
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return tlsConfig, nil
+}
+
+// certReloader serves a certificate from files that are replaced on rotation, e.g. by cert-manager.
+// The files are checked for changes at most once per interval during handshakes, and a certificate
+// that fails to load keeps the previous one in use.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	interval time.Duration
+	now      func() time.Time
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTimes  []time.Time
+	checkedAt time.Time
+}
+
+func newCertReloader(certFile, keyFile string, interval time.Duration) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile, interval: interval, now: time.Now}
+	modTimes, err := filewatch.ModTimes(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.load(modTimes); err != nil {
+		return nil, err
+	}
+	r.checkedAt = r.now()
+	return r, nil
+}
+
+// GetCertificate returns the current certificate, reloading it if its files changed
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if now := r.now(); now.Sub(r.checkedAt) >= r.interval {
+		r.checkedAt = now
+		r.reloadIfChanged()
+	}
+	return r.cert, nil
+}
+
+func (r *certReloader) reloadIfChanged() {
+	modTimes, err := filewatch.ModTimes(r.certFile, r.keyFile)
+	if err != nil {
+		log.Errorf("Failed to check server certificate for changes, keeping the current one: %v", err)
+		return
+	}
+	if !filewatch.Changed(r.modTimes, modTimes) {
+		return
+	}
+	if err := r.load(modTimes); err != nil {
+		// cert and key may be replaced one after another, the next check picks up the pair
+		log.Errorf("Failed to reload server certificate, keeping the current one: %v", err)
+		return
+	}
+	log.Infof("Reloaded server certificate from %s", r.certFile)
+}
+
+func (r *certReloader) load(modTimes []time.Time) error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load server certificate: %w", err)
+	}
+	r.cert = &cert
+	r.modTimes = modTimes
+	return nil
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bizflycloud/external-dns-bizflycloud-webhook/cmd/webhook/init/configuration"
+	"github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/webhook"
+)
+
+// rotate replaces a certificate and key like cert-manager does, with a later modification time
+func rotate(t *testing.T, certFile, keyFile string, cert, key []byte, modTime time.Time) {
+	for file, content := range map[string][]byte{certFile: cert, keyFile: key} {
+		if err := os.WriteFile(file, content, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(file, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func servedName(t *testing.T, cert *tls.Certificate) string {
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed.Subject.CommonName
+}
+
+func TestCertReloader(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	cert, key := ca.issue(t, "first", x509.ExtKeyUsageServerAuth)
+	start := time.Now().Add(-time.Hour)
+	rotate(t, certFile, keyFile, cert, key, start)
+
+	reloader, err := newCertReloader(certFile, keyFile, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	reloader.now = func() time.Time { return now }
+
+	cert, key = ca.issue(t, "second", x509.ExtKeyUsageServerAuth)
+	rotate(t, certFile, keyFile, cert, key, start.Add(time.Minute))
+
+	// the files are not checked again within the interval
+	served, _ := reloader.GetCertificate(nil)
+	if name := servedName(t, served); name != "first" {
+		t.Errorf("expected certificate 'first' within the interval, got '%s'", name)
+	}
+
+	now = now.Add(time.Minute)
+	served, _ = reloader.GetCertificate(nil)
+	if name := servedName(t, served); name != "second" {
+		t.Errorf("expected rotated certificate 'second', got '%s'", name)
+	}
+
+	// a broken pair keeps the current certificate
+	rotate(t, certFile, keyFile, cert, []byte("broken"), start.Add(2*time.Minute))
+	now = now.Add(time.Minute)
+	served, _ = reloader.GetCertificate(nil)
+	if name := servedName(t, served); name != "second" {
+		t.Errorf("expected certificate 'second' to be kept, got '%s'", name)
+	}
+}
+
+func TestTLSServing(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	cert, key := ca.issue(t, "localhost", x509.ExtKeyUsageServerAuth)
+	start := time.Now().Add(-time.Hour)
+	rotate(t, certFile, keyFile, cert, key, start)
+
+	mockProvider.testCase = testCase{}
+	config := defaultConfig()
+	config.ServerPort = 8891
+	config.ServerTLSCertFile = certFile
+	config.ServerTLSKeyFile = keyFile
+	config.ServerTLSReloadInterval = 0
+	srv := Init(config, webhook.New(mockProvider))
+	defer func() { _ = srv.Shutdown(context.Background()) }()
+	time.Sleep(100 * time.Millisecond)
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(ca.pem)
+	servedSerial := func() string {
+		// a new transport for every request, so that each one does a handshake
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+		response, err := client.Get("https://localhost:8891/healthz")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = response.Body.Close()
+		return response.TLS.PeerCertificates[0].SerialNumber.String()
+	}
+
+	first := servedSerial()
+	cert, key = ca.issue(t, "localhost", x509.ExtKeyUsageServerAuth)
+	rotate(t, certFile, keyFile, cert, key, start.Add(time.Minute))
+	if second := servedSerial(); second == first {
+		t.Errorf("expected the rotated certificate to be served without a restart")
+	}
+}
+
+func TestTLSConfiguration(t *testing.T) {
+	for name, config := range map[string]configuration.Config{
+		"client CA without certificate": {AuthClientCAFile: "ca.crt"},
+		"certificate without key":       {ServerTLSCertFile: "tls.crt"},
+		"missing certificate":           {ServerTLSCertFile: "missing.crt", ServerTLSKeyFile: "missing.key"},
+	} {
+		if _, err := tlsConfig(config); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
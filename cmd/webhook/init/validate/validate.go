@@ -0,0 +1,127 @@
+package validate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/bizflycloud/external-dns-bizflycloud-webhook/cmd/webhook/init/configuration"
+	"github.com/bizflycloud/external-dns-bizflycloud-webhook/cmd/webhook/init/dnsprovider"
+	"github.com/bizflycloud/external-dns-bizflycloud-webhook/cmd/webhook/init/logging"
+	"github.com/bizflycloud/external-dns-bizflycloud-webhook/internal/bizflycloud"
+)
+
+// zoneLister is implemented by the Bizfly Cloud provider
+type zoneLister interface {
+	ZoneStatuses(ctx context.Context) ([]bizflycloud.ZoneStatus, error)
+}
+
+// Run checks that the webhook can start with the configuration and writes a report of every step to w:
+// the configuration is parsed and validated, the filters are compiled, the accounts authenticate against
+// Bizfly Cloud and their zones are listed together with whether the webhook would manage them.
+// It stops at the first failing step and returns its error.
+func Run(ctx context.Context, w io.Writer, configFile string, environment map[string]string) error {
+	config, err := configuration.Load(configFile, environment)
+	if err != nil {
+		return fail(w, "configuration", err)
+	}
+	logging.Init(config)
+	source := "environment"
+	if configFile != "" {
+		source = configFile + " and environment"
+	}
+	pass(w, "configuration", "read from "+source)
+
+	domainFilter, zoneIDFilter, err := dnsprovider.Filters(config)
+	if err != nil {
+		return fail(w, "filters", err)
+	}
+	pass(w, "filters", dnsprovider.DescribeFilters(config))
+
+	// nothing is changed while validating, so there is nothing to audit and no audit log to create
+	providerConfig := config.Provider
+	providerConfig.AuditLog = ""
+	p, err := bizflycloud.NewBizflyCloudProvider(domainFilter, zoneIDFilter, &providerConfig)
+	if err != nil {
+		return fail(w, "authentication", err)
+	}
+	accounts := "1 account"
+	if n := len(config.Provider.Accounts); n > 1 {
+		accounts = fmt.Sprintf("%d accounts", n)
+	}
+	pass(w, "authentication", accounts)
+
+	lister, ok := p.(zoneLister)
+	if !ok {
+		return fail(w, "zones", fmt.Errorf("the provider can not list its zones"))
+	}
+	zones, err := lister.ZoneStatuses(ctx)
+	if err != nil {
+		return fail(w, "zones", err)
+	}
+	managed := 0
+	for _, zone := range zones {
+		if zone.Excluded == "" {
+			managed++
+		}
+	}
+	pass(w, "zones", fmt.Sprintf("%d of %d zones are managed", managed, len(zones)))
+	if len(zones) > 0 {
+		fmt.Fprintln(w)
+		writeZones(w, zones, len(config.Provider.Accounts) > 0)
+	}
+	return nil
+}
+
+func pass(w io.Writer, step, details string) {
+	fmt.Fprintf(w, "[ok]     %s: %s\n", step, details)
+}
+
+// fail reports the error of a step, errors joined from several ones are listed one per line
+func fail(w io.Writer, step string, err error) error {
+	fmt.Fprintf(w, "[FAILED] %s\n", step)
+	for _, line := range strings.Split(err.Error(), "\n") {
+		fmt.Fprintf(w, "         %s\n", line)
+	}
+	return fmt.Errorf("%s: %w", step, err)
+}
+
+// writeZones lists the zones as a table, with the records that would be managed.
+func writeZones(w io.Writer, zones []bizflycloud.ZoneStatus, withAccount bool) {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if withAccount {
+		fmt.Fprint(table, "ACCOUNT\t")
+	}
+	fmt.Fprintln(table, "ZONE\tID\tSTATE\tMANAGED\tRECORDS")
+	for _, zone := range zones {
+		if withAccount {
+			fmt.Fprintf(table, "%s\t", zone.Account)
+		}
+		managed, records := "yes", formatRecords(zone.Records)
+		if zone.Excluded != "" {
+			managed, records = "no, excluded by "+zone.Excluded, "-"
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\n", zone.Name, zone.ID, zone.State, managed, records)
+	}
+	_ = table.Flush()
+}
+
+// formatRecords lists the record counts by type, e.g. "A: 2, TXT: 2"
+func formatRecords(records map[string]int) string {
+	if len(records) == 0 {
+		return "none"
+	}
+	types := make([]string, 0, len(records))
+	for recordType := range records {
+		types = append(types, recordType)
+	}
+	sort.Strings(types)
+	counts := make([]string, len(types))
+	for i, recordType := range types {
+		counts[i] = fmt.Sprintf("%s: %d", recordType, records[recordType])
+	}
+	return strings.Join(counts, ", ")
+}
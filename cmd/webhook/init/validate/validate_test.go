@@ -0,0 +1,52 @@
+package validate
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bizflycloud/external-dns-bizflycloud-webhook/internal/bizflycloud"
+)
+
+func TestRunReportsInvalidConfiguration(t *testing.T) {
+	var report bytes.Buffer
+	err := Run(context.Background(), &report, "", map[string]string{
+		"REGEXP_DOMAIN_FILTER": "(",
+		"SERVER_PORT":          "0",
+	})
+	assert.Error(t, err)
+	assert.Equal(t, `[FAILED] configuration
+         SERVER_PORT must be between 1 and 65535, got 0
+         REGEXP_DOMAIN_FILTER is invalid: error parsing regexp: missing closing ): `+"`(`"+`
+         BFC_APP_CREDENTIAL_ID and BFC_APP_CREDENTIAL_SECRET or BFC_ACCOUNTS are required
+`, report.String())
+}
+
+func TestRunDoesNotOpenAuditLog(t *testing.T) {
+	auditLog := filepath.Join(t.TempDir(), "audit.log")
+	_ = Run(context.Background(), &bytes.Buffer{}, "", map[string]string{
+		"BFC_APP_CREDENTIAL_ID":     "id",
+		"BFC_APP_CREDENTIAL_SECRET": "secret",
+		"AUDIT_LOG":                 auditLog,
+	})
+	_, err := os.Stat(auditLog)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestWriteZones(t *testing.T) {
+	var report bytes.Buffer
+	writeZones(&report, []bizflycloud.ZoneStatus{
+		{Account: "prod", ID: "Z001", Name: "bar.com", State: "active", Records: map[string]int{"TXT": 2, "A": 1}},
+		{Account: "prod", ID: "Z002", Name: "foo.com", State: "active", Records: map[string]int{}},
+		{Account: "staging", ID: "Z101", Name: "example.org", State: "inactive", Excluded: bizflycloud.ExcludedByDomainFilter},
+	}, true)
+	assert.Equal(t, `ACCOUNT  ZONE         ID    STATE     MANAGED                        RECORDS
+prod     bar.com      Z001  active    yes                            A: 1, TXT: 2
+prod     foo.com      Z002  active    yes                            none
+staging  example.org  Z101  inactive  no, excluded by domain filter  -
+`, report.String())
+}
@@ -1,12 +1,17 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"os"
 
 	"github.com/bizflycloud/external-dns-bizflycloud-webhook/cmd/webhook/init/configuration"
 	"github.com/bizflycloud/external-dns-bizflycloud-webhook/cmd/webhook/init/dnsprovider"
 	"github.com/bizflycloud/external-dns-bizflycloud-webhook/cmd/webhook/init/logging"
 	"github.com/bizflycloud/external-dns-bizflycloud-webhook/cmd/webhook/init/server"
+	"github.com/bizflycloud/external-dns-bizflycloud-webhook/cmd/webhook/init/validate"
+	"github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/tracing"
 	"github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/webhook"
 	log "github.com/sirupsen/logrus"
 )
@@ -29,13 +34,50 @@ var (
 )
 
 func main() {
+	configFile := flag.String("config", "", "YAML or JSON configuration file, environment variables override its settings")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [--config file] [validate]\n\n", os.Args[0])
+		fmt.Fprintln(flag.CommandLine.Output(), "validate checks the configuration, the credentials and the managed zones, then exits")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	switch flag.Arg(0) {
+	case "":
+	case "validate":
+		validateCommand(*configFile, flag.Args()[1:])
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
 	fmt.Printf(banner, Version, Gitsha)
-	logging.Init()
-	config := configuration.Init()
+	config := configuration.Init(*configFile)
+	logging.Init(config)
+	shutdownTracing, err := tracing.Init(config.TracingExporter, config.TracingSampleRatio)
+	if err != nil {
+		log.Fatalf("Failed to initialize tracing: %v", err)
+	}
 	provider, err := dnsprovider.Init(config)
 	if err != nil {
 		log.Fatalf("Failed to initialize DNS provider: %v", err)
 	}
 	srv := server.Init(config, webhook.New(provider))
-	server.ShutdownGracefully(srv)
+	metricsSrv := server.InitMetrics(config)
+	server.ShutdownGracefully(srv, metricsSrv)
+	if err := shutdownTracing(context.Background()); err != nil {
+		log.Errorf("Failed to flush traces: %v", err)
+	}
+}
+
+// validateCommand runs the validate subcommand and exits, with 1 if the validation failed
+func validateCommand(configFile string, args []string) {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	flags.StringVar(&configFile, "config", configFile, "YAML or JSON configuration file, environment variables override its settings")
+	_ = flags.Parse(args)
+
+	if err := validate.Run(context.Background(), os.Stdout, configFile, configuration.Environ()); err != nil {
+		os.Exit(1)
+	}
+	os.Exit(0)
 }
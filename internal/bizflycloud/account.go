@@ -0,0 +1,188 @@
+package bizflycloud
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/endpoint"
+	"github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/plan"
+	"github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/provider"
+	"github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/requestid"
+)
+
+// multiAccountProvider manages the zones of several Bizfly accounts. Records are merged across the accounts
+// and every change is passed on to the account owning the zone of its name.
+type multiAccountProvider struct {
+	provider.BaseProvider
+	accounts []*BizflyCloudProvider
+}
+
+// newMultiAccountProvider checks that no zone is served by more than one of the accounts.
+func newMultiAccountProvider(ctx context.Context, domainFilter endpoint.DomainFilter, accounts []*BizflyCloudProvider) (*multiAccountProvider, error) {
+	m := &multiAccountProvider{BaseProvider: *provider.NewBaseProvider(domainFilter), accounts: accounts}
+	if _, err := m.zoneOwners(ctx); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// zoneOwners returns the index of the account owning each zone, by zone name. Zones that are excluded by the
+// zone filter are included too, so that changes within them are skipped by their account instead of ending
+// up in a parent zone of another account. It fails if a zone that is not excluded is served by several accounts.
+func (m *multiAccountProvider) zoneOwners(ctx context.Context) (map[string]int, error) {
+	owners := map[string]int{}
+	excluded := map[string]int{}
+	for i, account := range m.accounts {
+		zones, err := account.listDNSZonesWithAutoPagination(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("account %s: %w", account.account, err)
+		}
+		for _, zone := range zones {
+			if !account.zoneFilter.Match(zone) {
+				excluded[zone.Name] = i
+				continue
+			}
+			if owner, ok := owners[zone.Name]; ok {
+				return nil, fmt.Errorf("zone %s is served by accounts %s and %s, every zone must belong to a single account",
+					zone.Name, m.accounts[owner].account, account.account)
+			}
+			owners[zone.Name] = i
+		}
+	}
+	for name, i := range excluded {
+		if _, ok := owners[name]; !ok {
+			owners[name] = i
+		}
+	}
+	return owners, nil
+}
+
+// Records returns the records of all accounts.
+func (m *multiAccountProvider) Records(ctx context.Context) ([]*endpoint.Endpoint, error) {
+	endpoints := []*endpoint.Endpoint{}
+	recordCounts := map[[2]string]int{}
+	for _, account := range m.accounts {
+		accountEndpoints, accountCounts, err := account.records(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("account %s: %w", account.account, err)
+		}
+		endpoints = append(endpoints, accountEndpoints...)
+		for zoneAndType, count := range accountCounts {
+			recordCounts[zoneAndType] += count
+		}
+	}
+	setRecordsMetric(recordCounts)
+	return endpoints, nil
+}
+
+// ApplyChanges applies the changes of every account with the provider of the account. Changes matching no zone
+// of any account are skipped, just like a single account skips them. An account failing as a whole does not
+// keep the other accounts from applying their changes, its error is returned together with the failed records.
+func (m *multiAccountProvider) ApplyChanges(ctx context.Context, changes *plan.Changes) error {
+	accountChanges, _, err := m.route(ctx, changes)
+	if err != nil {
+		return err
+	}
+	applyErr := &provider.ApplyChangesError{}
+	var accountErrs []error
+	for i, account := range m.accounts {
+		if accountChanges[i] == nil {
+			continue
+		}
+		err := account.ApplyChanges(ctx, accountChanges[i])
+		var accountErr *provider.ApplyChangesError
+		switch {
+		case errors.As(err, &accountErr):
+			for _, recordErr := range accountErr.Errors {
+				applyErr.Add(recordErr)
+			}
+		case err != nil:
+			accountErrs = append(accountErrs, fmt.Errorf("account %s: %w", account.account, err))
+		}
+	}
+	if len(accountErrs) == 0 {
+		return applyErr.ErrorOrNil()
+	}
+	return errors.Join(append(accountErrs, applyErr.ErrorOrNil())...)
+}
+
+// PlanChanges merges the plans of all accounts.
+func (m *multiAccountProvider) PlanChanges(ctx context.Context, changes *plan.Changes) (*provider.ChangePlan, error) {
+	accountChanges, unmatched, err := m.route(ctx, changes)
+	if err != nil {
+		return nil, err
+	}
+	changePlan := &provider.ChangePlan{Zones: []*provider.ZonePlan{}, Skipped: unmatched}
+	for i, account := range m.accounts {
+		if accountChanges[i] == nil {
+			continue
+		}
+		accountPlan, err := account.PlanChanges(ctx, accountChanges[i])
+		if err != nil {
+			return nil, fmt.Errorf("account %s: %w", account.account, err)
+		}
+		changePlan.Zones = append(changePlan.Zones, accountPlan.Zones...)
+		changePlan.Skipped = append(changePlan.Skipped, accountPlan.Skipped...)
+	}
+	return changePlan, nil
+}
+
+// route splits the changes by the account owning the zone of their name, accounts without changes get nil.
+// Changes matching no zone are returned as errors, the previous state of updates is not reported separately.
+func (m *multiAccountProvider) route(ctx context.Context, changes *plan.Changes) ([]*plan.Changes, []*provider.RecordError, error) {
+	owners, err := m.zoneOwners(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	zones := provider.ZoneIDName{}
+	for name := range owners {
+		zones.Add(name, name)
+	}
+
+	accountChanges := make([]*plan.Changes, len(m.accounts))
+	unmatched := []*provider.RecordError{}
+	add := func(action string, report bool, endpoints []*endpoint.Endpoint, field func(*plan.Changes) *[]*endpoint.Endpoint) {
+		for _, ep := range endpoints {
+			_, zoneName := zones.FindZone(ep.DNSName)
+			if zoneName == "" {
+				if !report {
+					continue
+				}
+				requestid.Logger(ctx).Debugf("Skipping record %s because no hosted zone matching record DNS Name was detected", ep.DNSName)
+				unmatched = append(unmatched, provider.NewRecordError("", ep.DNSName, ep.RecordType, ep.SetIdentifier, action, errNoMatchingZone))
+				continue
+			}
+			owner := owners[zoneName]
+			if accountChanges[owner] == nil {
+				accountChanges[owner] = &plan.Changes{}
+			}
+			*field(accountChanges[owner]) = append(*field(accountChanges[owner]), ep)
+		}
+	}
+	add(bizflyCloudCreate, true, changes.Create, func(c *plan.Changes) *[]*endpoint.Endpoint { return &c.Create })
+	add(bizflyCloudUpdate, false, changes.UpdateOld, func(c *plan.Changes) *[]*endpoint.Endpoint { return &c.UpdateOld })
+	add(bizflyCloudUpdate, true, changes.UpdateNew, func(c *plan.Changes) *[]*endpoint.Endpoint { return &c.UpdateNew })
+	add(bizflyCloudDelete, true, changes.Delete, func(c *plan.Changes) *[]*endpoint.Endpoint { return &c.Delete })
+	return accountChanges, unmatched, nil
+}
+
+// AdjustEndpoints normalizes the endpoints like every single account does.
+func (m *multiAccountProvider) AdjustEndpoints(endpoints []*endpoint.Endpoint) []*endpoint.Endpoint {
+	return m.accounts[0].AdjustEndpoints(endpoints)
+}
+
+// AdjustEndpointsContext normalizes the endpoints like every single account does.
+func (m *multiAccountProvider) AdjustEndpointsContext(ctx context.Context, endpoints []*endpoint.Endpoint) []*endpoint.Endpoint {
+	return m.accounts[0].AdjustEndpointsContext(ctx, endpoints)
+}
+
+// Ready fails unless all accounts are ready.
+func (m *multiAccountProvider) Ready(ctx context.Context) error {
+	for _, account := range m.accounts {
+		if err := account.Ready(ctx); err != nil {
+			return fmt.Errorf("account %s: %w", account.account, err)
+		}
+	}
+	return nil
+}
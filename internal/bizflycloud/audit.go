@@ -0,0 +1,53 @@
+package bizflycloud
+
+import (
+	"context"
+
+	"github.com/bizflycloud/gobizfly"
+
+	"github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/audit"
+	"github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/requestid"
+)
+
+// auditRecord is the state of a record before it was changed
+type auditRecord struct {
+	TTL  int           `json:"ttl"`
+	Data []interface{} `json:"data"`
+}
+
+// auditOperation appends an executed operation to the audit log.
+func (p *BizflyCloudProvider) auditOperation(ctx context.Context, zone *gobizfly.ExtendedZone, change *bizflyCloudChange, operation recordOperation, recordID string, err error) {
+	if p.audit == nil {
+		return
+	}
+	event := audit.Event{
+		CorrelationID: requestid.FromContext(ctx),
+		Account:       p.account,
+		ZoneID:        operation.ZoneID,
+		Zone:          zone.Name,
+		Record:        change.NormalRecord.Name,
+		Type:          change.NormalRecord.Type,
+		SetIdentifier: change.setIdentifier(),
+		Action:        operation.Action,
+		RecordID:      recordID,
+		Result:        audit.ResultApplied,
+	}
+	// the record of the zone snapshot the operation was planned against
+	for _, r := range zone.RecordsSet {
+		if operation.RecordID != "" && r.ID == operation.RecordID {
+			event.Old = auditRecord{TTL: r.TTL, Data: r.Data}
+			break
+		}
+	}
+	switch operation.Action {
+	case bizflyCloudCreate:
+		event.New = getCreateDNSRecordParam(*operation.Change)
+	case bizflyCloudUpdate:
+		event.New = getUpdateDNSRecordParam(*operation.Change)
+	}
+	if err != nil {
+		event.Result = audit.ResultFailed
+		event.Error = err.Error()
+	}
+	p.audit.Write(event)
+}
@@ -0,0 +1,135 @@
+package bizflycloud
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/bizflycloud/gobizfly"
+)
+
+// cachingDNS keeps zone listings and zone details of a bizflyCloudDNS for a limited time, so that
+// the Records call of every external-dns loop and the ApplyChanges following it share API calls.
+// Zones are invalidated after a successful write to one of their records.
+type cachingDNS struct {
+	bizflyCloudDNS
+	ttl time.Duration
+	now func() time.Time
+
+	mu        sync.Mutex
+	zoneLists map[gobizfly.ListOptions]cachedZoneList
+	zones     map[string]cachedZone
+}
+
+type cachedZoneList struct {
+	resp    *gobizfly.ListZoneResp
+	expires time.Time
+}
+
+type cachedZone struct {
+	zone    *gobizfly.ExtendedZone
+	expires time.Time
+}
+
+func newCachingDNS(client bizflyCloudDNS, ttl time.Duration) *cachingDNS {
+	return &cachingDNS{
+		bizflyCloudDNS: client,
+		ttl:            ttl,
+		now:            time.Now,
+		zoneLists:      map[gobizfly.ListOptions]cachedZoneList{},
+		zones:          map[string]cachedZone{},
+	}
+}
+
+// ListZones returns a page of zones, from the cache if it has not expired yet.
+func (c *cachingDNS) ListZones(ctx context.Context, opts *gobizfly.ListOptions) (*gobizfly.ListZoneResp, error) {
+	var key gobizfly.ListOptions
+	if opts != nil {
+		key = *opts
+	}
+	c.mu.Lock()
+	cached, ok := c.zoneLists[key]
+	c.mu.Unlock()
+	if ok && c.now().Before(cached.expires) {
+		return cached.resp, nil
+	}
+
+	resp, err := c.bizflyCloudDNS.ListZones(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.zoneLists[key] = cachedZoneList{resp: resp, expires: c.now().Add(c.ttl)}
+	c.mu.Unlock()
+	return resp, nil
+}
+
+// GetZone returns a zone with its records, from the cache if it has not expired yet.
+func (c *cachingDNS) GetZone(ctx context.Context, zoneID string) (*gobizfly.ExtendedZone, error) {
+	c.mu.Lock()
+	cached, ok := c.zones[zoneID]
+	c.mu.Unlock()
+	if ok && c.now().Before(cached.expires) {
+		return cached.zone, nil
+	}
+
+	zone, err := c.bizflyCloudDNS.GetZone(ctx, zoneID)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.zones[zoneID] = cachedZone{zone: zone, expires: c.now().Add(c.ttl)}
+	c.mu.Unlock()
+	return zone, nil
+}
+
+// CreateRecord creates a record and invalidates its zone.
+func (c *cachingDNS) CreateRecord(ctx context.Context, zoneID string, crpl interface{}) (*gobizfly.Record, error) {
+	record, err := c.bizflyCloudDNS.CreateRecord(ctx, zoneID, crpl)
+	if err != nil {
+		return nil, err
+	}
+	c.invalidateZone(zoneID)
+	return record, nil
+}
+
+// UpdateRecord updates a record and invalidates the zone holding it.
+func (c *cachingDNS) UpdateRecord(ctx context.Context, recordID string, urpl interface{}) (*gobizfly.Record, error) {
+	record, err := c.bizflyCloudDNS.UpdateRecord(ctx, recordID, urpl)
+	if err != nil {
+		return nil, err
+	}
+	c.invalidateRecord(recordID)
+	return record, nil
+}
+
+// DeleteRecord deletes a record and invalidates the zone holding it.
+func (c *cachingDNS) DeleteRecord(ctx context.Context, recordID string) error {
+	if err := c.bizflyCloudDNS.DeleteRecord(ctx, recordID); err != nil {
+		return err
+	}
+	c.invalidateRecord(recordID)
+	return nil
+}
+
+func (c *cachingDNS) invalidateZone(zoneID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.zones, zoneID)
+}
+
+// invalidateRecord drops the cached zone holding the record. Updates and deletes only
+// carry the record ID, so if no cached zone holds it every zone is dropped.
+func (c *cachingDNS) invalidateRecord(recordID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for zoneID, cached := range c.zones {
+		for _, r := range cached.zone.RecordsSet {
+			if r.ID == recordID {
+				delete(c.zones, zoneID)
+				return
+			}
+		}
+	}
+	c.zones = map[string]cachedZone{}
+}
@@ -0,0 +1,71 @@
+package bizflycloud
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/bizflycloud/gobizfly"
+)
+
+const (
+	// dnsServiceName is the gobizfly catalog name of the DNS service
+	dnsServiceName = "dns"
+)
+
+// bizflyCloudClient adapts the gobizfly DNS service to bizflyCloudDNS.
+// gobizfly does not model the routing_policy_data of policy records, so
+// GetZone decodes the zone itself and hands the policy over as the only
+// element of the record data.
+type bizflyCloudClient struct {
+	gobizfly.DNSService
+	client *gobizfly.Client
+}
+
+type policyAwareRecord struct {
+	gobizfly.Record
+	RoutingPolicyData *RoutingPolicyData `json:"routing_policy_data"`
+}
+
+type policyAwareZone struct {
+	gobizfly.Zone
+	RecordsSet []policyAwareRecord `json:"record_set"`
+}
+
+func newBizflyCloudClient(client *gobizfly.Client) *bizflyCloudClient {
+	return &bizflyCloudClient{
+		DNSService: client.DNS,
+		client:     client,
+	}
+}
+
+// GetZone returns a zone with all of its records, including policy records.
+func (c *bizflyCloudClient) GetZone(ctx context.Context, zoneID string) (*gobizfly.ExtendedZone, error) {
+	req, err := c.client.NewRequest(ctx, http.MethodGet, dnsServiceName, "/zone/"+zoneID, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var zone policyAwareZone
+	if err := json.NewDecoder(resp.Body).Decode(&zone); err != nil {
+		return nil, err
+	}
+
+	extendedZone := &gobizfly.ExtendedZone{
+		Zone:       zone.Zone,
+		RecordsSet: make([]gobizfly.Record, 0, len(zone.RecordsSet)),
+	}
+	for _, r := range zone.RecordsSet {
+		record := r.Record
+		if r.RoutingPolicyData != nil && len(r.RoutingPolicyData.RoutingData) > 0 {
+			record.Data = []interface{}{*r.RoutingPolicyData}
+		}
+		extendedZone.RecordsSet = append(extendedZone.RecordsSet, record)
+	}
+	return extendedZone, nil
+}
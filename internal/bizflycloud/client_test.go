@@ -0,0 +1,102 @@
+package bizflycloud
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/endpoint"
+	"github.com/bizflycloud/gobizfly"
+	"github.com/maxatome/go-testdeep/td"
+	"github.com/stretchr/testify/require"
+)
+
+// zoneResponse is a zone as the Bizfly API returns it, with a geo, a weighted and an MX record.
+const zoneResponse = `{
+	"id": "Z001",
+	"name": "bar.com",
+	"active": true,
+	"record_set": [
+		{
+			"id": "R001",
+			"zone_id": "Z001",
+			"name": "geo",
+			"type": "A",
+			"ttl": 60,
+			"data": [],
+			"routing_policy_data": {"routing_data": {"HN": ["1.1.1.1", "1.1.1.2"]}}
+		},
+		{
+			"id": "R002",
+			"zone_id": "Z001",
+			"name": "app",
+			"type": "A",
+			"ttl": 60,
+			"data": [],
+			"routing_policy_data": {"routing_data": {"canary": ["2.2.2.2"]}, "weights": {"canary": 10}}
+		},
+		{
+			"id": "R003",
+			"zone_id": "Z001",
+			"name": "@",
+			"type": "MX",
+			"ttl": 300,
+			"data": [{"value": "mx1.bar.com.", "priority": 10}],
+			"routing_policy_data": null
+		}
+	]
+}`
+
+func TestBizflycloudClientGetZone(t *testing.T) {
+	s := newKeystoneServer(t, "2024-01-01T01:00:00Z")
+	s.dnsHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/dns/zones":
+			_, _ = w.Write([]byte(`{"zones": [{"id": "Z001", "name": "bar.com", "active": true}], "_meta": {"max_results": 1, "total": 1, "page": 1}}`))
+		case "/dns/zone/Z001":
+			_, _ = w.Write([]byte(zoneResponse))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	_, client := newTestTokenManager(t, s, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	dns := newBizflyCloudClient(client)
+
+	zone, err := dns.GetZone(context.Background(), "Z001")
+	require.NoError(t, err)
+	td.Cmp(t, zone.RecordsSet, []gobizfly.Record{
+		{
+			ID: "R001", ZoneID: "Z001", Name: "geo", Type: endpoint.RecordTypeA, TTL: 60,
+			Data: []interface{}{RoutingPolicyData{RoutingData: map[string][]string{"HN": {"1.1.1.1", "1.1.1.2"}}}},
+		},
+		{
+			ID: "R002", ZoneID: "Z001", Name: "app", Type: endpoint.RecordTypeA, TTL: 60,
+			Data: []interface{}{RoutingPolicyData{
+				RoutingData: map[string][]string{"canary": {"2.2.2.2"}},
+				Weights:     map[string]int{"canary": 10},
+			}},
+		},
+		{
+			ID: "R003", ZoneID: "Z001", Name: "@", Type: endpoint.RecordTypeMX, TTL: 300,
+			Data: []interface{}{map[string]interface{}{"value": "mx1.bar.com.", "priority": float64(10)}},
+		},
+	})
+
+	provider := &BizflyCloudProvider{
+		Client:       dns,
+		domainFilter: endpoint.NewDomainFilter([]string{"bar.com"}),
+		apiPageSize:  100,
+	}
+	records, err := provider.Records(context.Background())
+	require.NoError(t, err)
+	td.Cmp(t, records, td.Bag(
+		endpoint.NewEndpointWithTTL("geo.bar.com", endpoint.RecordTypeA, 60, "1.1.1.1", "1.1.1.2").
+			WithSetIdentifier("HN").
+			WithProviderSpecific(providerSpecificRoutingRegion, "HN"),
+		endpoint.NewEndpointWithTTL("app.bar.com", endpoint.RecordTypeA, 60, "2.2.2.2").
+			WithSetIdentifier("canary").
+			WithProviderSpecific(providerSpecificWeight, "10"),
+		endpoint.NewEndpointWithTTL("bar.com", endpoint.RecordTypeMX, 300, "10 mx1.bar.com"),
+	))
+}
@@ -1,11 +1,160 @@
 package bizflycloud
 
-// Configuration holds configuration from environmental variables
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/provider"
+)
+
+// Configuration holds configuration from environmental variables or the provider section of the configuration file
 type Configuration struct {
-	APICredentialId     string `env:"BFC_APP_CREDENTIAL_ID,notEmpty"`
-	APICredentialSecret string `env:"BFC_APP_CREDENTIAL_SECRET,notEmpty"`
-	Debug               bool   `env:"IONOS_DEBUG" envDefault:"false"`
-	DryRun              bool   `env:"DRY_RUN" envDefault:"false"`
-	Region              string `env:"BFC_REGION" envDefault:"HN"`
-	APIPageSize         int    `env:"BFC_API_PAGE_SIZE" envDefault:"100"`
+	APICredentialId     string        `env:"BFC_APP_CREDENTIAL_ID" yaml:"credentialId"`
+	APICredentialSecret string        `env:"BFC_APP_CREDENTIAL_SECRET" yaml:"credentialSecret"`
+	ProjectID           string        `env:"BFC_PROJECT_ID" yaml:"projectId"`
+	Debug               bool          `env:"IONOS_DEBUG" envDefault:"false" yaml:"debug"`
+	DryRun              bool          `env:"DRY_RUN" envDefault:"false" yaml:"dryRun"`
+	Region              string        `env:"BFC_REGION" envDefault:"HN" yaml:"region"`
+	APIPageSize         int           `env:"BFC_API_PAGE_SIZE" envDefault:"100" yaml:"apiPageSize"`
+	ApplyConcurrency    int           `env:"BFC_APPLY_CONCURRENCY" envDefault:"1" yaml:"applyConcurrency"`
+	TokenRefreshBefore  time.Duration `env:"BFC_TOKEN_REFRESH_BEFORE" envDefault:"5m" yaml:"tokenRefreshBefore"`
+	RetryMaxAttempts    int           `env:"BFC_RETRY_MAX_ATTEMPTS" envDefault:"4" yaml:"retryMaxAttempts"`
+	RetryInitialBackoff time.Duration `env:"BFC_RETRY_INITIAL_BACKOFF" envDefault:"500ms" yaml:"retryInitialBackoff"`
+	RetryMaxBackoff     time.Duration `env:"BFC_RETRY_MAX_BACKOFF" envDefault:"30s" yaml:"retryMaxBackoff"`
+	RateLimitRPS        float64       `env:"BFC_RATE_LIMIT_RPS" envDefault:"10" yaml:"rateLimitRPS"`
+	RateLimitBurst      int           `env:"BFC_RATE_LIMIT_BURST" envDefault:"10" yaml:"rateLimitBurst"`
+	ReadinessStaleness  time.Duration `env:"BFC_READINESS_STALENESS" envDefault:"1m" yaml:"readinessStaleness"`
+	CacheTTL            time.Duration `env:"BFC_CACHE_TTL" envDefault:"30s" yaml:"cacheTTL"`
+	// ZoneStateFilter selects zones by state, any of active, inactive and deleted. All zones are selected by default.
+	ZoneStateFilter []string `env:"BFC_ZONE_STATE_FILTER" envDefault:"" yaml:"zoneStateFilter"`
+	// Accounts replaces the credentials above with several Bizfly projects, as a JSON list
+	Accounts Accounts `env:"BFC_ACCOUNTS" yaml:"accounts"`
+	// APICredentialIdFile and APICredentialSecretFile read the credentials from mounted files instead
+	APICredentialIdFile     string `env:"BFC_APP_CREDENTIAL_ID_FILE" yaml:"credentialIdFile"`
+	APICredentialSecretFile string `env:"BFC_APP_CREDENTIAL_SECRET_FILE" yaml:"credentialSecretFile"`
+	// CredentialsReloadInterval is how often credential files are checked for changes
+	CredentialsReloadInterval time.Duration `env:"BFC_CREDENTIALS_RELOAD_INTERVAL" envDefault:"30s" yaml:"credentialsReloadInterval"`
+	// AuditLog is the file every mutation is appended to as a JSON line, or stdout
+	AuditLog string `env:"AUDIT_LOG" yaml:"auditLog"`
+}
+
+// Account is a Bizfly project managed by the webhook. Its zones must not overlap with the ones of other accounts.
+type Account struct {
+	Name             string `json:"name" yaml:"name"`
+	CredentialID     string `json:"credentialId" yaml:"credentialId"`
+	CredentialSecret string `json:"credentialSecret" yaml:"credentialSecret"`
+	// CredentialIDFile and CredentialSecretFile read the credentials from mounted files instead
+	CredentialIDFile     string `json:"credentialIdFile,omitempty" yaml:"credentialIdFile,omitempty"`
+	CredentialSecretFile string `json:"credentialSecretFile,omitempty" yaml:"credentialSecretFile,omitempty"`
+	ProjectID            string `json:"projectId,omitempty" yaml:"projectId,omitempty"`
+	// Region defaults to BFC_REGION
+	Region string `json:"region,omitempty" yaml:"region,omitempty"`
+	// DomainFilter restricts the zones of the account, it defaults to DOMAIN_FILTER
+	DomainFilter []string `json:"domainFilter,omitempty" yaml:"domainFilter,omitempty"`
+}
+
+// Accounts is read from a JSON list in the environment, or a list in the configuration file
+type Accounts []Account
+
+// UnmarshalText parses the JSON list of accounts
+func (a *Accounts) UnmarshalText(text []byte) error {
+	return json.Unmarshal(text, (*[]Account)(a))
+}
+
+// Validate checks all settings and reports every invalid one. Credentials configured as files are read.
+func (c *Configuration) Validate() error {
+	var errs []error
+	check := func(valid bool, format string, args ...interface{}) {
+		if !valid {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	check(c.APIPageSize > 0, "BFC_API_PAGE_SIZE must be positive, got %d", c.APIPageSize)
+	check(c.ApplyConcurrency > 0, "BFC_APPLY_CONCURRENCY must be positive, got %d", c.ApplyConcurrency)
+	check(c.TokenRefreshBefore >= 0, "BFC_TOKEN_REFRESH_BEFORE must not be negative")
+	check(c.RetryMaxAttempts > 0, "BFC_RETRY_MAX_ATTEMPTS must be positive, got %d", c.RetryMaxAttempts)
+	check(c.RetryInitialBackoff >= 0, "BFC_RETRY_INITIAL_BACKOFF must not be negative")
+	check(c.RetryMaxBackoff >= c.RetryInitialBackoff, "BFC_RETRY_MAX_BACKOFF must not be less than BFC_RETRY_INITIAL_BACKOFF")
+	check(c.RateLimitRPS >= 0, "BFC_RATE_LIMIT_RPS must not be negative")
+	check(c.RateLimitRPS == 0 || c.RateLimitBurst > 0, "BFC_RATE_LIMIT_BURST must be positive, got %d", c.RateLimitBurst)
+	check(c.ReadinessStaleness >= 0, "BFC_READINESS_STALENESS must not be negative")
+	check(c.CacheTTL >= 0, "BFC_CACHE_TTL must not be negative")
+	check(c.CredentialsReloadInterval >= 0, "BFC_CREDENTIALS_RELOAD_INTERVAL must not be negative")
+	if _, err := newZoneFilter(provider.ZoneIDFilter{}, c.ZoneStateFilter); err != nil {
+		errs = append(errs, fmt.Errorf("BFC_ZONE_STATE_FILTER is invalid: %w", err))
+	}
+	if _, err := c.accounts(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// accounts returns the configured accounts, or a single unnamed account holding the credentials of
+// BFC_APP_CREDENTIAL_ID and BFC_APP_CREDENTIAL_SECRET. Credentials configured as files are read.
+func (c *Configuration) accounts() ([]Account, error) {
+	if len(c.Accounts) == 0 {
+		switch {
+		case c.APICredentialId != "" && c.APICredentialIdFile != "":
+			return nil, fmt.Errorf("only one of BFC_APP_CREDENTIAL_ID and BFC_APP_CREDENTIAL_ID_FILE can be set")
+		case c.APICredentialSecret != "" && c.APICredentialSecretFile != "":
+			return nil, fmt.Errorf("only one of BFC_APP_CREDENTIAL_SECRET and BFC_APP_CREDENTIAL_SECRET_FILE can be set")
+		case c.APICredentialId == "" && c.APICredentialIdFile == "" || c.APICredentialSecret == "" && c.APICredentialSecretFile == "":
+			return nil, fmt.Errorf("BFC_APP_CREDENTIAL_ID and BFC_APP_CREDENTIAL_SECRET or BFC_ACCOUNTS are required")
+		}
+		account := Account{
+			CredentialID:         c.APICredentialId,
+			CredentialSecret:     c.APICredentialSecret,
+			CredentialIDFile:     c.APICredentialIdFile,
+			CredentialSecretFile: c.APICredentialSecretFile,
+			ProjectID:            c.ProjectID,
+			Region:               c.Region,
+		}
+		if err := account.loadCredentials(); err != nil {
+			return nil, err
+		}
+		return []Account{account}, nil
+	}
+	if c.APICredentialId != "" || c.APICredentialSecret != "" || c.APICredentialIdFile != "" || c.APICredentialSecretFile != "" {
+		return nil, fmt.Errorf("BFC_ACCOUNTS can not be combined with BFC_APP_CREDENTIAL_ID and BFC_APP_CREDENTIAL_SECRET")
+	}
+
+	accounts := make([]Account, len(c.Accounts))
+	names := map[string]bool{}
+	for i, account := range c.Accounts {
+		switch {
+		case account.Name == "":
+			return nil, fmt.Errorf("account %d has no name", i+1)
+		case names[account.Name]:
+			return nil, fmt.Errorf("account %s is configured more than once", account.Name)
+		case account.CredentialID != "" && account.CredentialIDFile != "" ||
+			account.CredentialSecret != "" && account.CredentialSecretFile != "":
+			return nil, fmt.Errorf("account %s sets a credential both as value and as file", account.Name)
+		case account.CredentialID == "" && account.CredentialIDFile == "" ||
+			account.CredentialSecret == "" && account.CredentialSecretFile == "":
+			return nil, fmt.Errorf("account %s requires credentialId and credentialSecret", account.Name)
+		}
+		if err := account.loadCredentials(); err != nil {
+			return nil, fmt.Errorf("account %s: %w", account.Name, err)
+		}
+		names[account.Name] = true
+		if account.Region == "" {
+			account.Region = c.Region
+		}
+		accounts[i] = account
+	}
+	return accounts, nil
+}
+
+// credentialFiles returns the files the credentials of the account are read from.
+func (a *Account) credentialFiles() credentialFiles {
+	return credentialFiles{idFile: a.CredentialIDFile, secretFile: a.CredentialSecretFile}
+}
+
+// loadCredentials reads the credentials that are configured as files.
+func (a *Account) loadCredentials() error {
+	var err error
+	a.CredentialID, a.CredentialSecret, err = a.credentialFiles().load(a.CredentialID, a.CredentialSecret)
+	return err
 }
@@ -0,0 +1,126 @@
+package bizflycloud
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/filewatch"
+	"github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/requestid"
+)
+
+// credentialFiles are mounted files holding the credentials of an account, e.g. from a Kubernetes secret.
+// Either file may be unset, the configured value is used then.
+type credentialFiles struct {
+	idFile     string
+	secretFile string
+}
+
+// configured tells whether any credential is read from a file.
+func (f credentialFiles) configured() bool {
+	return f.idFile != "" || f.secretFile != ""
+}
+
+// load returns the credentials, with the contents of the files replacing the given values.
+func (f credentialFiles) load(id, secret string) (string, string, error) {
+	var err error
+	if f.idFile != "" {
+		if id, err = readCredentialFile(f.idFile); err != nil {
+			return "", "", err
+		}
+	}
+	if f.secretFile != "" {
+		if secret, err = readCredentialFile(f.secretFile); err != nil {
+			return "", "", err
+		}
+	}
+	return id, secret, nil
+}
+
+// modTimes returns the modification times of the files, unset files have the zero time.
+func (f credentialFiles) modTimes() ([]time.Time, error) {
+	return filewatch.ModTimes(f.idFile, f.secretFile)
+}
+
+func readCredentialFile(file string) (string, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to read credentials: %w", err)
+	}
+	value := strings.TrimSpace(string(content))
+	if value == "" {
+		return "", fmt.Errorf("credential file %s is empty", file)
+	}
+	return value, nil
+}
+
+// credentialWatch tracks the credential files of a token manager.
+type credentialWatch struct {
+	files    credentialFiles
+	interval time.Duration
+
+	mu        sync.Mutex
+	modTimes  []time.Time
+	checkedAt time.Time
+}
+
+// watchCredentials makes the token manager check the files for changes at most once per interval.
+// The credentials of the files must already be in use.
+func (m *tokenManager) watchCredentials(files credentialFiles, interval time.Duration) error {
+	modTimes, err := files.modTimes()
+	if err != nil {
+		return fmt.Errorf("failed to read credentials: %w", err)
+	}
+	m.credentials.mu.Lock()
+	defer m.credentials.mu.Unlock()
+	m.credentials.files = files
+	m.credentials.interval = interval
+	m.credentials.modTimes = modTimes
+	m.credentials.checkedAt = m.now()
+	return nil
+}
+
+// checkCredentials reloads the credentials if their files changed. Credentials that fail to load,
+// e.g. while the files are being replaced, keep the current ones in use.
+func (m *tokenManager) checkCredentials(ctx context.Context) {
+	w := &m.credentials
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	now := m.now()
+	if !w.files.configured() || now.Sub(w.checkedAt) < w.interval {
+		return
+	}
+	w.checkedAt = now
+
+	modTimes, err := w.files.modTimes()
+	if err != nil {
+		requestid.Logger(ctx).Errorf("Failed to check credentials for changes, keeping the current ones: %v", err)
+		return
+	}
+	if !filewatch.Changed(w.modTimes, modTimes) {
+		return
+	}
+
+	m.mu.RLock()
+	request := m.request
+	m.mu.RUnlock()
+	id, secret, err := w.files.load(request.AppCredID, request.AppCredSecret)
+	if err != nil {
+		requestid.Logger(ctx).Errorf("Failed to reload credentials, keeping the current ones: %v", err)
+		return
+	}
+	w.modTimes = modTimes
+	if id == request.AppCredID && secret == request.AppCredSecret {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.request.AppCredID = id
+	m.request.AppCredSecret = secret
+	m.rotated = true
+	requestid.Logger(ctx).Info("Credentials changed, re-authenticating")
+}
@@ -0,0 +1,53 @@
+package bizflycloud
+
+import (
+	"context"
+	"time"
+
+	"github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/metrics"
+	"github.com/bizflycloud/gobizfly"
+)
+
+// instrumentedDNS records count, errors and latency of every call that reaches the Bizfly API.
+type instrumentedDNS struct {
+	bizflyCloudDNS
+}
+
+func newInstrumentedDNS(client bizflyCloudDNS) *instrumentedDNS {
+	return &instrumentedDNS{bizflyCloudDNS: client}
+}
+
+func (i *instrumentedDNS) ListZones(ctx context.Context, opts *gobizfly.ListOptions) (*gobizfly.ListZoneResp, error) {
+	start := time.Now()
+	resp, err := i.bizflyCloudDNS.ListZones(ctx, opts)
+	metrics.ObserveAPICall("ListZones", start, err)
+	return resp, err
+}
+
+func (i *instrumentedDNS) GetZone(ctx context.Context, zoneID string) (*gobizfly.ExtendedZone, error) {
+	start := time.Now()
+	zone, err := i.bizflyCloudDNS.GetZone(ctx, zoneID)
+	metrics.ObserveAPICall("GetZone", start, err)
+	return zone, err
+}
+
+func (i *instrumentedDNS) CreateRecord(ctx context.Context, zoneID string, crpl interface{}) (*gobizfly.Record, error) {
+	start := time.Now()
+	record, err := i.bizflyCloudDNS.CreateRecord(ctx, zoneID, crpl)
+	metrics.ObserveAPICall("CreateRecord", start, err)
+	return record, err
+}
+
+func (i *instrumentedDNS) UpdateRecord(ctx context.Context, recordID string, urpl interface{}) (*gobizfly.Record, error) {
+	start := time.Now()
+	record, err := i.bizflyCloudDNS.UpdateRecord(ctx, recordID, urpl)
+	metrics.ObserveAPICall("UpdateRecord", start, err)
+	return record, err
+}
+
+func (i *instrumentedDNS) DeleteRecord(ctx context.Context, recordID string) error {
+	start := time.Now()
+	err := i.bizflyCloudDNS.DeleteRecord(ctx, recordID)
+	metrics.ObserveAPICall("DeleteRecord", start, err)
+	return err
+}
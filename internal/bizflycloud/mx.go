@@ -0,0 +1,74 @@
+package bizflycloud
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/endpoint"
+	"github.com/bizflycloud/gobizfly"
+)
+
+// MXRecord is the Bizfly representation of an MX record. Unlike a NormalRecord
+// its data pairs every mail exchanger with a priority.
+type MXRecord struct {
+	Name string
+	Type string
+	TTL  int
+	Data []gobizfly.MXData
+}
+
+// newMXRecord builds an MXRecord from external-dns targets in the "priority host" format.
+func newMXRecord(name string, ttl int, targets []string) (*MXRecord, error) {
+	data := make([]gobizfly.MXData, 0, len(targets))
+	for _, target := range targets {
+		mx, err := parseMXTarget(target)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, mx)
+	}
+	return &MXRecord{
+		Name: name,
+		Type: endpoint.RecordTypeMX,
+		TTL:  ttl,
+		Data: data,
+	}, nil
+}
+
+// parseMXTarget parses an external-dns MX target such as "10 mail.example.com".
+func parseMXTarget(target string) (gobizfly.MXData, error) {
+	fields := strings.Fields(target)
+	if len(fields) != 2 {
+		return gobizfly.MXData{}, fmt.Errorf("invalid MX target %q, expected \"<priority> <host>\"", target)
+	}
+	priority, err := strconv.Atoi(fields[0])
+	if err != nil || priority < 0 || priority > 65535 {
+		return gobizfly.MXData{}, fmt.Errorf("invalid MX priority in target %q", target)
+	}
+	return gobizfly.MXData{
+		Value:    strings.TrimSuffix(fields[1], "."),
+		Priority: priority,
+	}, nil
+}
+
+// mxTarget converts one element of an MX record's data back into an external-dns target.
+// Records decoded from the API carry their data as JSON objects.
+func mxTarget(data interface{}) (string, error) {
+	switch d := data.(type) {
+	case gobizfly.MXData:
+		return fmt.Sprintf("%d %s", d.Priority, strings.TrimSuffix(d.Value, ".")), nil
+	case map[string]interface{}:
+		value, ok := d["value"].(string)
+		if !ok {
+			return "", fmt.Errorf("MX data has no value: %v", d)
+		}
+		priority, ok := d["priority"].(float64)
+		if !ok {
+			return "", fmt.Errorf("MX data has no priority: %v", d)
+		}
+		return fmt.Sprintf("%d %s", int(priority), strings.TrimSuffix(value, ".")), nil
+	default:
+		return "", fmt.Errorf("unexpected MX data: %v", data)
+	}
+}
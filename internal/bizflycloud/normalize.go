@@ -0,0 +1,143 @@
+package bizflycloud
+
+import (
+	"context"
+
+	"github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/endpoint"
+	"github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/requestid"
+	"github.com/bizflycloud/gobizfly"
+)
+
+// Bizfly zones may hold several records with the same name and type, while external-dns
+// expects a single endpoint for them. Records are merged into one endpoint when reading,
+// and changes to such an endpoint are reconciled with the existing record layout when writing.
+
+// recordOperation is a single API call needed to apply a change.
+type recordOperation struct {
+	Action   string
+	ZoneID   string
+	RecordID string
+	// Change holds the payload of creates and updates
+	Change *bizflyCloudChange
+}
+
+// mergeEndpoints merges endpoints sharing a name, type and set identifier into one endpoint
+// holding the union of their targets. The TTL and properties of the first endpoint are kept.
+func mergeEndpoints(ctx context.Context, endpoints []*endpoint.Endpoint) []*endpoint.Endpoint {
+	merged := make([]*endpoint.Endpoint, 0, len(endpoints))
+	byKey := make(map[string]*endpoint.Endpoint, len(endpoints))
+	for _, ep := range endpoints {
+		existing, ok := byKey[endpointKey(ep)]
+		if !ok {
+			byKey[endpointKey(ep)] = ep
+			merged = append(merged, ep)
+			continue
+		}
+		if existing.RecordTTL != ep.RecordTTL {
+			requestid.Logger(ctx).Debugf("Records of %s (%s) have different TTLs, reporting %d", ep.DNSName, ep.RecordType, existing.RecordTTL)
+		}
+		for _, target := range ep.Targets {
+			if !containsTarget(existing.Targets, target) {
+				existing.Targets = append(existing.Targets, target)
+			}
+		}
+	}
+	return merged
+}
+
+// reconcileRecords plans the operations turning the records backing an endpoint into the desired
+// targets of an update. Targets that are still desired stay in their record, new targets replace
+// removed ones in place, and records left without targets are deleted.
+func reconcileRecords(zoneID string, change *bizflyCloudChange, records []gobizfly.Record) ([]recordOperation, error) {
+	desired := change.NormalRecord.Data
+
+	current := make([][]string, len(records))
+	kept := make([][]string, len(records))
+	for i, r := range records {
+		targets, err := recordTargets(r)
+		if err != nil {
+			return nil, err
+		}
+		current[i] = targets
+		for _, target := range targets {
+			if containsTarget(desired, target) && !containsTargetIn(kept, target) {
+				kept[i] = append(kept[i], target)
+			}
+		}
+	}
+
+	added := []string{}
+	for _, target := range desired {
+		if !containsTargetIn(kept, target) {
+			added = append(added, target)
+		}
+	}
+
+	// new targets first take the place of removed ones
+	for i := range records {
+		for len(added) > 0 && len(kept[i]) < len(current[i]) {
+			kept[i] = append(kept[i], added[0])
+			added = added[1:]
+		}
+	}
+	// anything left goes to the first record still holding targets
+	if len(added) > 0 {
+		receiver := 0
+		for i := range kept {
+			if len(kept[i]) > 0 {
+				receiver = i
+				break
+			}
+		}
+		kept[receiver] = append(kept[receiver], added...)
+	}
+
+	operations := []recordOperation{}
+	for i, r := range records {
+		if len(kept[i]) == 0 {
+			operations = append(operations, recordOperation{Action: bizflyCloudDelete, ZoneID: zoneID, RecordID: r.ID})
+			continue
+		}
+		if r.TTL == change.NormalRecord.TTL && endpoint.Targets(kept[i]).Same(current[i]) {
+			continue
+		}
+		partial, err := change.withTargets(kept[i])
+		if err != nil {
+			return nil, err
+		}
+		operations = append(operations, recordOperation{Action: bizflyCloudUpdate, ZoneID: zoneID, RecordID: r.ID, Change: partial})
+	}
+	return operations, nil
+}
+
+// withTargets returns a copy of the change carrying only the given targets.
+func (c *bizflyCloudChange) withTargets(targets []string) (*bizflyCloudChange, error) {
+	partial := *c
+	partial.NormalRecord.Data = targets
+	if c.MXRecord != nil {
+		mx, err := newMXRecord(c.MXRecord.Name, c.MXRecord.TTL, targets)
+		if err != nil {
+			return nil, err
+		}
+		partial.MXRecord = mx
+	}
+	return &partial, nil
+}
+
+func containsTarget(targets []string, target string) bool {
+	for _, t := range targets {
+		if t == target {
+			return true
+		}
+	}
+	return false
+}
+
+func containsTargetIn(groups [][]string, target string) bool {
+	for _, targets := range groups {
+		if containsTarget(targets, target) {
+			return true
+		}
+	}
+	return false
+}
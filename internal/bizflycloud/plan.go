@@ -0,0 +1,91 @@
+package bizflycloud
+
+import (
+	"context"
+	"errors"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/plan"
+	"github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/provider"
+)
+
+// errNoMatchingZone is reported for changes whose name does not belong to any zone of the account
+var errNoMatchingZone = errors.New("no hosted zone matches the record name")
+
+// PlanChanges resolves the changes into the operations ApplyChanges would send to the API, without executing them.
+// The zones and their records are read just like ApplyChanges does, so the plan holds the record IDs that would
+// be changed. Changes that would be skipped or fail before reaching the API are reported as skipped.
+func (p *BizflyCloudProvider) PlanChanges(ctx context.Context, changes *plan.Changes) (*provider.ChangePlan, error) {
+	changePlan := &provider.ChangePlan{Zones: []*provider.ZonePlan{}, Skipped: []*provider.RecordError{}}
+	bizflycloudChanges, invalid := p.newBizflyCloudChanges(changes)
+	changePlan.Skipped = append(changePlan.Skipped, invalid...)
+	if len(bizflycloudChanges) == 0 {
+		return changePlan, nil
+	}
+
+	zones, unmatched, err := p.fetchZoneChanges(ctx, bizflycloudChanges)
+	if err != nil {
+		return nil, err
+	}
+	for _, change := range unmatched {
+		changePlan.Skipped = append(changePlan.Skipped, change.recordError("", errNoMatchingZone))
+	}
+
+	for _, zone := range zones {
+		if zone.err != nil {
+			for _, change := range zone.changes {
+				changePlan.Skipped = append(changePlan.Skipped, change.recordError(zone.zoneID, zone.err))
+			}
+			continue
+		}
+		zonePlan := &provider.ZonePlan{ID: zone.zoneID, Name: zone.zoneName, Operations: []*provider.PlannedOperation{}}
+		// operations are listed in the order the tasks of the zone would execute them
+		for _, task := range groupChangesByName(zone.zoneID, zone.detailZone, zone.changes) {
+			for _, change := range task.changes {
+				operations, err := p.planChange(ctx, zone.zoneID, zone.detailZone, change)
+				if err != nil {
+					changePlan.Skipped = append(changePlan.Skipped, change.recordError(zone.zoneID, err))
+					continue
+				}
+				for _, operation := range operations {
+					zonePlan.Operations = append(zonePlan.Operations, plannedOperation(change, operation))
+				}
+			}
+		}
+		changePlan.Zones = append(changePlan.Zones, zonePlan)
+	}
+	return changePlan, nil
+}
+
+// plannedOperation describes an operation together with the request body it would send.
+func plannedOperation(change *bizflyCloudChange, operation recordOperation) *provider.PlannedOperation {
+	planned := &provider.PlannedOperation{
+		Action:        operation.Action,
+		ChangeAction:  change.Action,
+		Record:        change.NormalRecord.Name,
+		Type:          change.NormalRecord.Type,
+		SetIdentifier: change.setIdentifier(),
+		RecordID:      operation.RecordID,
+	}
+	switch operation.Action {
+	case bizflyCloudCreate:
+		planned.Payload = getCreateDNSRecordParam(*operation.Change)
+	case bizflyCloudUpdate:
+		planned.Payload = getUpdateDNSRecordParam(*operation.Change)
+	}
+	return planned
+}
+
+// logPlannedOperations logs the operations a change would send in DryRun mode.
+func (p *BizflyCloudProvider) logPlannedOperations(ctx context.Context, logger *log.Entry, task changeTask, change *bizflyCloudChange) {
+	operations, err := p.planChange(ctx, task.zoneID, task.detailZone, change)
+	if err != nil {
+		logger.Warnf("Dry run, change would fail: %v", err)
+		return
+	}
+	for _, operation := range operations {
+		logger.WithFields(log.Fields{"operation": operation.Action, "recordID": operation.RecordID}).
+			Info("Dry run, skipping API call")
+	}
+}
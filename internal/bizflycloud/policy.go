@@ -0,0 +1,294 @@
+package bizflycloud
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/endpoint"
+	"github.com/bizflycloud/gobizfly"
+)
+
+const (
+	// providerSpecificRoutingRegion turns an endpoint into a geo routing-policy record served to the given region
+	providerSpecificRoutingRegion = "webhook/bizflycloud-routing-region"
+	// providerSpecificWeight turns an endpoint into a weighted routing-policy record named by its set identifier
+	providerSpecificWeight = "webhook/bizflycloud-weight"
+	// providerSpecificHealthCheckProtocol attaches a health check using HTTP, HTTPS or TCP to a routing-policy record
+	providerSpecificHealthCheckProtocol = "webhook/bizflycloud-health-check-protocol"
+	// providerSpecificHealthCheckPort is the port probed by the health check
+	providerSpecificHealthCheckPort = "webhook/bizflycloud-health-check-port"
+	// providerSpecificHealthCheckPath is the path requested by HTTP and HTTPS health checks
+	providerSpecificHealthCheckPath = "webhook/bizflycloud-health-check-path"
+	// providerSpecificHealthCheckInterval is the number of seconds between two probes
+	providerSpecificHealthCheckInterval = "webhook/bizflycloud-health-check-interval"
+
+	// defaultRoutingKey is the routing key of health-checked records without a region or weight
+	defaultRoutingKey = "default"
+	// defaultHealthCheckInterval is used when no interval is configured
+	defaultHealthCheckInterval = 30
+	// minHealthCheckInterval is the shortest interval Bizfly accepts
+	minHealthCheckInterval = 10
+)
+
+var healthCheckProperties = []string{
+	providerSpecificHealthCheckProtocol,
+	providerSpecificHealthCheckPort,
+	providerSpecificHealthCheckPath,
+	providerSpecificHealthCheckInterval,
+}
+
+// RoutingPolicyData mirrors the routing_policy_data block Bizfly attaches to policy records.
+type RoutingPolicyData struct {
+	// RoutingData maps a routing key onto the addresses served for it.
+	// For geo records the key is a region such as HN or HCM, for weighted
+	// records it is the set identifier.
+	RoutingData map[string][]string `json:"routing_data"`
+	// Weights maps a routing key onto its share of the traffic. It is only set for weighted records.
+	Weights map[string]int `json:"weights,omitempty"`
+	// HealthCheck withholds addresses that fail their probe, which fails traffic over to the remaining ones.
+	HealthCheck *HealthCheck `json:"healthcheck,omitempty"`
+}
+
+// HealthCheck describes how Bizfly probes the addresses of a policy record.
+type HealthCheck struct {
+	Protocol string `json:"protocol"`
+	Port     int    `json:"port"`
+	Path     string `json:"path,omitempty"`
+	Interval int    `json:"interval"`
+}
+
+// PolicyRecord is the Bizfly representation of a routing-policy record.
+// Every external-dns set identifier is stored as its own Bizfly record.
+type PolicyRecord struct {
+	Name              string
+	Type              string
+	TTL               int
+	SetIdentifier     string
+	RoutingPolicyData RoutingPolicyData
+}
+
+// CreatePolicyRecordPayload - contains the payload for creating a routing-policy record.
+type CreatePolicyRecordPayload struct {
+	gobizfly.BaseCreateRecordPayload
+	RoutingPolicyData RoutingPolicyData `json:"routing_policy_data"`
+}
+
+// UpdatePolicyRecordPayload - contains the payload for updating a routing-policy record.
+type UpdatePolicyRecordPayload struct {
+	gobizfly.BaseUpdateRecordPayload
+	RoutingPolicyData RoutingPolicyData `json:"routing_policy_data"`
+}
+
+// newPolicyRecord builds a PolicyRecord from the provider specific properties of an endpoint.
+// It returns nil if the endpoint describes a normal record.
+func newPolicyRecord(ep *endpoint.Endpoint, ttl int) (*PolicyRecord, error) {
+	regionProperty, isGeo := ep.GetProviderSpecificProperty(providerSpecificRoutingRegion)
+	weightProperty, isWeighted := ep.GetProviderSpecificProperty(providerSpecificWeight)
+
+	healthCheck, err := parseHealthCheck(ep)
+	if err != nil {
+		return nil, err
+	}
+
+	record := &PolicyRecord{
+		Name: ep.DNSName,
+		Type: ep.RecordType,
+		TTL:  ttl,
+	}
+	switch {
+	case isGeo && isWeighted:
+		return nil, fmt.Errorf("%s cannot combine %s and %s", ep.DNSName, providerSpecificRoutingRegion, providerSpecificWeight)
+	case isGeo:
+		region := strings.ToUpper(regionProperty.Value)
+		if region == "" {
+			return nil, fmt.Errorf("%s of %s must not be empty", providerSpecificRoutingRegion, ep.DNSName)
+		}
+		// Bizfly does not store set identifiers, so they are read back from the region.
+		if !strings.EqualFold(ep.SetIdentifier, region) {
+			return nil, fmt.Errorf("set identifier %q of %s must match its routing region %q", ep.SetIdentifier, ep.DNSName, region)
+		}
+		record.SetIdentifier = region
+		record.RoutingPolicyData.RoutingData = map[string][]string{region: ep.Targets}
+	case isWeighted:
+		if ep.SetIdentifier == "" {
+			return nil, fmt.Errorf("weighted record %s requires a set identifier", ep.DNSName)
+		}
+		weight, err := strconv.Atoi(weightProperty.Value)
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("invalid %s %q of %s", providerSpecificWeight, weightProperty.Value, ep.DNSName)
+		}
+		record.SetIdentifier = ep.SetIdentifier
+		record.RoutingPolicyData.RoutingData = map[string][]string{ep.SetIdentifier: ep.Targets}
+		record.RoutingPolicyData.Weights = map[string]int{ep.SetIdentifier: weight}
+	case healthCheck != nil:
+		// without a region or weight there is nothing to store a set identifier in
+		if ep.SetIdentifier != "" {
+			return nil, fmt.Errorf("set identifier of %s requires %s or %s", ep.DNSName, providerSpecificRoutingRegion, providerSpecificWeight)
+		}
+		record.RoutingPolicyData.RoutingData = map[string][]string{defaultRoutingKey: ep.Targets}
+	default:
+		return nil, nil
+	}
+	record.RoutingPolicyData.HealthCheck = healthCheck
+	return record, nil
+}
+
+// parseHealthCheck reads the health check of an endpoint and fills in defaults for missing settings.
+// It returns nil if the endpoint carries no health check properties.
+func parseHealthCheck(ep *endpoint.Endpoint) (*HealthCheck, error) {
+	properties := map[string]string{}
+	for _, name := range healthCheckProperties {
+		if property, ok := ep.GetProviderSpecificProperty(name); ok {
+			properties[name] = property.Value
+		}
+	}
+	if len(properties) == 0 {
+		return nil, nil
+	}
+
+	healthCheck := &HealthCheck{
+		Protocol: strings.ToUpper(properties[providerSpecificHealthCheckProtocol]),
+		Path:     properties[providerSpecificHealthCheckPath],
+		Interval: defaultHealthCheckInterval,
+	}
+	switch healthCheck.Protocol {
+	case "", "HTTP":
+		healthCheck.Protocol = "HTTP"
+		healthCheck.Port = 80
+	case "HTTPS":
+		healthCheck.Port = 443
+	case "TCP":
+		if healthCheck.Path != "" {
+			return nil, fmt.Errorf("%s of %s is not supported by TCP health checks", providerSpecificHealthCheckPath, ep.DNSName)
+		}
+	default:
+		return nil, fmt.Errorf("invalid %s %q of %s, expected HTTP, HTTPS or TCP", providerSpecificHealthCheckProtocol, healthCheck.Protocol, ep.DNSName)
+	}
+	if healthCheck.Protocol != "TCP" && healthCheck.Path == "" {
+		healthCheck.Path = "/"
+	}
+
+	if value, ok := properties[providerSpecificHealthCheckPort]; ok {
+		port, err := strconv.Atoi(value)
+		if err != nil || port < 1 || port > 65535 {
+			return nil, fmt.Errorf("invalid %s %q of %s", providerSpecificHealthCheckPort, value, ep.DNSName)
+		}
+		healthCheck.Port = port
+	}
+	if healthCheck.Port == 0 {
+		return nil, fmt.Errorf("%s of %s is required for TCP health checks", providerSpecificHealthCheckPort, ep.DNSName)
+	}
+
+	if value, ok := properties[providerSpecificHealthCheckInterval]; ok {
+		interval, err := strconv.Atoi(value)
+		if err != nil || interval < minHealthCheckInterval {
+			return nil, fmt.Errorf("invalid %s %q of %s, expected at least %d seconds", providerSpecificHealthCheckInterval, value, ep.DNSName, minHealthCheckInterval)
+		}
+		healthCheck.Interval = interval
+	}
+	return healthCheck, nil
+}
+
+// normalizePolicyEndpoint validates the routing-policy properties of an endpoint and rewrites them
+// in the form Records reports them back, so that the planner does not see permanent diffs.
+func normalizePolicyEndpoint(ep *endpoint.Endpoint) error {
+	if property, ok := ep.GetProviderSpecificProperty(providerSpecificRoutingRegion); ok {
+		region := strings.ToUpper(property.Value)
+		setProviderSpecific(ep, providerSpecificRoutingRegion, region)
+		// the set identifier is read back from the region, in upper case
+		if ep.SetIdentifier == "" || strings.EqualFold(ep.SetIdentifier, region) {
+			ep.SetIdentifier = region
+		}
+	}
+
+	policy, err := newPolicyRecord(ep, defaultBizflyCloudRecordTTL)
+	if err != nil || policy == nil {
+		return err
+	}
+	if healthCheck := policy.RoutingPolicyData.HealthCheck; healthCheck != nil {
+		values := healthCheckPropertyValues(healthCheck)
+		for _, name := range healthCheckProperties {
+			if value, ok := values[name]; ok {
+				setProviderSpecific(ep, name, value)
+			}
+		}
+	}
+	return nil
+}
+
+// healthCheckPropertyValues returns the provider specific properties describing a health check.
+func healthCheckPropertyValues(healthCheck *HealthCheck) map[string]string {
+	values := map[string]string{
+		providerSpecificHealthCheckProtocol: healthCheck.Protocol,
+		providerSpecificHealthCheckPort:     strconv.Itoa(healthCheck.Port),
+		providerSpecificHealthCheckInterval: strconv.Itoa(healthCheck.Interval),
+	}
+	if healthCheck.Path != "" {
+		values[providerSpecificHealthCheckPath] = healthCheck.Path
+	}
+	return values
+}
+
+// setProviderSpecific sets a provider specific property, replacing any existing value.
+func setProviderSpecific(ep *endpoint.Endpoint, name, value string) {
+	for i := range ep.ProviderSpecific {
+		if ep.ProviderSpecific[i].Name == name {
+			ep.ProviderSpecific[i].Value = value
+			return
+		}
+	}
+	ep.WithProviderSpecific(name, value)
+}
+
+// routingPolicy returns the routing policy of a Bizfly record, if it is a policy record.
+// bizflyCloudClient.GetZone hands the policy over as the only element of the record data.
+func routingPolicy(r gobizfly.Record) (RoutingPolicyData, bool) {
+	if len(r.Data) != 1 {
+		return RoutingPolicyData{}, false
+	}
+	policy, ok := r.Data[0].(RoutingPolicyData)
+	return policy, ok
+}
+
+// policyEndpoint converts a Bizfly policy record back into the endpoint it was created from.
+func policyEndpoint(name string, r gobizfly.Record, policy RoutingPolicyData) (*endpoint.Endpoint, error) {
+	if len(policy.RoutingData) != 1 {
+		return nil, fmt.Errorf("policy record has %d routing keys, only records with a single key are managed", len(policy.RoutingData))
+	}
+	for key, targets := range policy.RoutingData {
+		ep := endpoint.NewEndpointWithTTL(name, r.Type, endpoint.TTL(r.TTL), targets...)
+		if ep == nil {
+			return nil, errInvalidRecordName
+		}
+		if weight, ok := policy.Weights[key]; ok {
+			ep.WithSetIdentifier(key).WithProviderSpecific(providerSpecificWeight, strconv.Itoa(weight))
+		} else if key != defaultRoutingKey || policy.HealthCheck == nil {
+			ep.WithSetIdentifier(key).WithProviderSpecific(providerSpecificRoutingRegion, key)
+		}
+		if policy.HealthCheck != nil {
+			values := healthCheckPropertyValues(policy.HealthCheck)
+			for _, name := range healthCheckProperties {
+				if value, ok := values[name]; ok {
+					ep.WithProviderSpecific(name, value)
+				}
+			}
+		}
+		return ep, nil
+	}
+	return nil, nil
+}
+
+// routingKey returns the key the record is stored under in Bizfly.
+func (r PolicyRecord) routingKey() string {
+	for key := range r.RoutingPolicyData.RoutingData {
+		return key
+	}
+	return ""
+}
+
+// hasRoutingKey reports whether the policy serves the given routing key.
+func (d RoutingPolicyData) hasRoutingKey(key string) bool {
+	_, ok := d.RoutingData[key]
+	return ok
+}
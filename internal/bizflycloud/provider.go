@@ -2,13 +2,23 @@ package bizflycloud
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
 
+	"github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/audit"
 	"github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/endpoint"
+	"github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/metrics"
 	"github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/plan"
 	"github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/provider"
+	"github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/requestid"
+	"github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/tracing"
 	"github.com/bizflycloud/gobizfly"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 const (
@@ -37,11 +47,23 @@ type bizflyCloudDNS interface {
 type BizflyCloudProvider struct {
 	provider.BaseProvider
 	Client bizflyCloudDNS
+	// account is the name of the configured account the provider manages, empty for a single account
+	account string
 	// only consider hosted zones managing domains ending in this suffix
 	domainFilter endpoint.DomainFilter
+	// only consider hosted zones with these IDs and states
+	zoneFilter zoneFilter
 	// page size when querying paginated APIs
 	apiPageSize int
-	DryRun      bool
+	// number of API calls ApplyChanges may have in flight
+	applyConcurrency int
+	// tokens keeps the Keystone token of the client valid
+	tokens *tokenManager
+	// readiness remembers whether zones could be listed recently
+	readiness *readinessCheck
+	// audit records every mutation sent to the API, nil when auditing is disabled
+	audit  *audit.Sink
+	DryRun bool
 }
 
 type NormalRecord struct {
@@ -51,15 +73,50 @@ type NormalRecord struct {
 	Data []string
 }
 
+// errRecordNotFound is reported for updates and deletes whose previous record does not exist
+var errRecordNotFound = errors.New("failed to find previous record")
+
+// errInvalidRecordName is reported for records external-dns can not represent as an endpoint
+var errInvalidRecordName = errors.New("record name has a label longer than 63 characters")
+
 // bizflyCloudChange differentiates between ChangActions
 type bizflyCloudChange struct {
 	Action       string
 	NormalRecord NormalRecord
+	// MXRecord is only set for MX changes and takes precedence over NormalRecord when building payloads
+	MXRecord *MXRecord
+	// PolicyRecord is only set for routing-policy changes and takes precedence over NormalRecord when building payloads
+	PolicyRecord *PolicyRecord
+	// Previous is the state external-dns computed an update against, taken from UpdateOld
+	Previous *endpoint.Endpoint
+}
+
+// setIdentifier returns the set identifier of the endpoint the change was built from.
+func (c *bizflyCloudChange) setIdentifier() string {
+	if c.PolicyRecord != nil {
+		return c.PolicyRecord.SetIdentifier
+	}
+	return ""
+}
+
+// key identifies the endpoint a change was built from.
+func (c *bizflyCloudChange) key() string {
+	return c.NormalRecord.Name + "|" + c.NormalRecord.Type + "|" + c.setIdentifier()
+}
+
+// recordError describes the failure of the change for a provider.ApplyChangesError.
+func (c *bizflyCloudChange) recordError(zoneID string, err error) *provider.RecordError {
+	return provider.NewRecordError(zoneID, c.NormalRecord.Name, c.NormalRecord.Type, c.setIdentifier(), c.Action, err)
+}
+
+// endpointKey identifies an endpoint by name, type and set identifier.
+func endpointKey(ep *endpoint.Endpoint) string {
+	return ep.DNSName + "|" + ep.RecordType + "|" + ep.SetIdentifier
 }
 
 func SupportedRecordType(recordType string) bool {
 	switch recordType {
-	case "A", "AAAA", "CNAME", "SRV", "TXT":
+	case "A", "AAAA", "CNAME", "MX", "SRV", "TXT":
 		return true
 	default:
 		return false
@@ -67,7 +124,27 @@ func SupportedRecordType(recordType string) bool {
 }
 
 // getUpdateDNSRecordParam is a function that returns the appropriate Record Param based on the bizflyCloudChange passed in
-func getUpdateDNSRecordParam(change bizflyCloudChange) gobizfly.UpdateNormalRecordPayload {
+func getUpdateDNSRecordParam(change bizflyCloudChange) interface{} {
+	if change.PolicyRecord != nil {
+		return UpdatePolicyRecordPayload{
+			BaseUpdateRecordPayload: gobizfly.BaseUpdateRecordPayload{
+				Name: change.PolicyRecord.Name,
+				TTL:  change.PolicyRecord.TTL,
+				Type: change.PolicyRecord.Type,
+			},
+			RoutingPolicyData: change.PolicyRecord.RoutingPolicyData,
+		}
+	}
+	if change.MXRecord != nil {
+		return gobizfly.UpdateMXRecordPayload{
+			BaseUpdateRecordPayload: gobizfly.BaseUpdateRecordPayload{
+				Name: change.MXRecord.Name,
+				TTL:  change.MXRecord.TTL,
+				Type: change.MXRecord.Type,
+			},
+			Data: change.MXRecord.Data,
+		}
+	}
 	return gobizfly.UpdateNormalRecordPayload{
 		BaseUpdateRecordPayload: gobizfly.BaseUpdateRecordPayload{
 			Name: change.NormalRecord.Name,
@@ -79,7 +156,27 @@ func getUpdateDNSRecordParam(change bizflyCloudChange) gobizfly.UpdateNormalReco
 }
 
 // getCreateDNSRecordParam is a function that returns the appropriate Record Param based on the bizflyCloudChange passed in
-func getCreateDNSRecordParam(change bizflyCloudChange) gobizfly.CreateNormalRecordPayload {
+func getCreateDNSRecordParam(change bizflyCloudChange) interface{} {
+	if change.PolicyRecord != nil {
+		return CreatePolicyRecordPayload{
+			BaseCreateRecordPayload: gobizfly.BaseCreateRecordPayload{
+				Name: change.PolicyRecord.Name,
+				TTL:  change.PolicyRecord.TTL,
+				Type: change.PolicyRecord.Type,
+			},
+			RoutingPolicyData: change.PolicyRecord.RoutingPolicyData,
+		}
+	}
+	if change.MXRecord != nil {
+		return gobizfly.CreateMXRecordPayload{
+			BaseCreateRecordPayload: gobizfly.BaseCreateRecordPayload{
+				Name: change.MXRecord.Name,
+				TTL:  change.MXRecord.TTL,
+				Type: change.MXRecord.Type,
+			},
+			Data: change.MXRecord.Data,
+		}
+	}
 	return gobizfly.CreateNormalRecordPayload{
 		BaseCreateRecordPayload: gobizfly.BaseCreateRecordPayload{
 			Name: change.NormalRecord.Name,
@@ -91,46 +188,124 @@ func getCreateDNSRecordParam(change bizflyCloudChange) gobizfly.CreateNormalReco
 }
 
 // NewBizflyCloudProvider initializes a new BizflyCloud DNS based Provider.
-func NewBizflyCloudProvider(domainFilter endpoint.DomainFilter, config *Configuration) (provider.Provider, error) {
-	fmt.Printf("%+v", config)
-	client, err := gobizfly.NewClient(gobizfly.WithRegionName(config.Region))
+// When accounts are configured, a provider is created for each of them and changes are routed to the account
+// owning their zone.
+func NewBizflyCloudProvider(domainFilter endpoint.DomainFilter, zoneIDFilter provider.ZoneIDFilter, config *Configuration) (provider.Provider, error) {
+	zoneFilter, err := newZoneFilter(zoneIDFilter, config.ZoneStateFilter)
+	if err != nil {
+		return nil, err
+	}
+	accounts, err := config.accounts()
+	if err != nil {
+		return nil, err
+	}
+	auditSink, err := audit.Open(config.AuditLog)
+	if err != nil {
+		return nil, err
+	}
+
+	providers := make([]*BizflyCloudProvider, 0, len(accounts))
+	for _, account := range accounts {
+		accountDomainFilter := domainFilter
+		if len(account.DomainFilter) > 0 {
+			accountDomainFilter = endpoint.NewDomainFilter(account.DomainFilter)
+		}
+		p, err := newAccountProvider(accountDomainFilter, zoneFilter, config, account, auditSink)
+		if err != nil {
+			// the single account configured by the credential variables has no name
+			if account.Name == "" {
+				return nil, err
+			}
+			return nil, fmt.Errorf("account %s: %w", account.Name, err)
+		}
+		providers = append(providers, p)
+	}
+	if len(config.Accounts) == 0 {
+		return providers[0], nil
+	}
+	return newMultiAccountProvider(context.Background(), domainFilter, providers)
+}
+
+// newAccountProvider authenticates with the credentials of an account and creates its provider.
+func newAccountProvider(domainFilter endpoint.DomainFilter, zoneFilter zoneFilter, config *Configuration, account Account, auditSink *audit.Sink) (*BizflyCloudProvider, error) {
+	tokens := newTokenManager(newAPIErrorTransport(http.DefaultTransport), config.TokenRefreshBefore)
+	client, err := gobizfly.NewClient(
+		gobizfly.WithRegionName(account.Region),
+		gobizfly.WithProjectId(account.ProjectID),
+		gobizfly.WithHTTPClient(&http.Client{Transport: tokens}))
 	if err != nil {
 		return nil, err
 	}
 	ctx := context.Background()
-	token, err := client.Token.Create(
+	err = tokens.authenticate(
 		ctx,
+		client,
 		&gobizfly.TokenCreateRequest{
 			AuthMethod:    auth_method,
-			AppCredID:     config.APICredentialId,
-			AppCredSecret: config.APICredentialSecret})
+			AppCredID:     account.CredentialID,
+			AppCredSecret: account.CredentialSecret,
+			ProjectID:     account.ProjectID})
 
 	if err != nil {
 		return nil, err
 	}
-	client.SetKeystoneToken(token)
+	if files := account.credentialFiles(); files.configured() {
+		if err := tokens.watchCredentials(files, config.CredentialsReloadInterval); err != nil {
+			return nil, err
+		}
+	}
+
+	var dnsClient bizflyCloudDNS = newAuthenticatedDNS(newInstrumentedDNS(newBizflyCloudClient(client)), tokens)
+	if config.RateLimitRPS > 0 {
+		dnsClient = newRateLimitedDNS(dnsClient, config.RateLimitRPS, config.RateLimitBurst)
+	}
+	if config.RetryMaxAttempts > 1 {
+		dnsClient = newRetryingDNS(dnsClient, config.RetryMaxAttempts, config.RetryInitialBackoff, config.RetryMaxBackoff)
+	}
+	readiness := newReadinessCheck(config.ReadinessStaleness)
+	dnsClient = readiness.watch(dnsClient)
+	if config.CacheTTL > 0 {
+		dnsClient = newCachingDNS(dnsClient, config.CacheTTL)
+	}
 
 	provider := &BizflyCloudProvider{
-		Client:       client.DNS,
-		domainFilter: domainFilter,
-		apiPageSize:  config.APIPageSize,
-		DryRun:       config.DryRun,
+		Client:           dnsClient,
+		account:          account.Name,
+		domainFilter:     domainFilter,
+		zoneFilter:       zoneFilter,
+		apiPageSize:      config.APIPageSize,
+		applyConcurrency: config.ApplyConcurrency,
+		tokens:           tokens,
+		readiness:        readiness,
+		audit:            auditSink,
+		DryRun:           config.DryRun,
 	}
 	return provider, nil
 }
 
-// listDNSZonesWithAutoPagination performs automatic pagination of results on requests to bizflycloud.ListZones with custom limit values
+// listDNSZonesWithAutoPagination lists the zones of the account that match the domain filter
 func (p *BizflyCloudProvider) listDNSZonesWithAutoPagination(ctx context.Context) ([]gobizfly.Zone, error) {
-	zones := []gobizfly.Zone{}
+	return p.listZonesMatching(ctx, p.domainFilter.Match)
+}
+
+// listZonesMatching performs automatic pagination of results on requests to bizflycloud.ListZones with custom limit values,
+// keeping the zones whose name passes match
+func (p *BizflyCloudProvider) listZonesMatching(ctx context.Context, match func(name string) bool) (zones []gobizfly.Zone, err error) {
+	ctx, span := tracing.Start(ctx, "bizflycloud.listZones")
+	defer func() {
+		span.SetAttributes(attribute.Int("zones", len(zones)))
+		tracing.End(span, err)
+	}()
+
+	zones = []gobizfly.Zone{}
 	listOptions := &gobizfly.ListOptions{Page: 1, Limit: p.apiPageSize}
 	for {
 		resp, err := p.Client.ListZones(ctx, listOptions)
-
 		if err != nil {
 			return nil, err
 		}
 		for _, zone := range resp.Zones {
-			if p.domainFilter.Match(zone.Name) {
+			if match(zone.Name) {
 				zones = append(zones, zone)
 			}
 		}
@@ -145,178 +320,599 @@ func (p *BizflyCloudProvider) listDNSZonesWithAutoPagination(ctx context.Context
 
 // Records returns the list of records.
 func (p *BizflyCloudProvider) Records(ctx context.Context) ([]*endpoint.Endpoint, error) {
+	endpoints, recordCounts, err := p.records(ctx)
+	if err != nil {
+		return nil, err
+	}
+	setRecordsMetric(recordCounts)
+	return endpoints, nil
+}
+
+// records returns the endpoints of all zones and the number of records per zone name and type.
+func (p *BizflyCloudProvider) records(ctx context.Context) ([]*endpoint.Endpoint, map[[2]string]int, error) {
 	zones, err := p.listDNSZonesWithAutoPagination(ctx)
 
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	endpoints := []*endpoint.Endpoint{}
+	recordCounts := map[[2]string]int{}
 	for _, zone := range zones {
-		detailZone, err := p.Client.GetZone(ctx, zone.ID)
+		if !p.zoneFilter.Match(zone) {
+			continue
+		}
+		detailZone, err := p.getZone(ctx, zone.ID)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		for _, r := range detailZone.RecordsSet {
 			if SupportedRecordType(r.Type) {
-				name := r.Name + "." + zone.Name
-
-				// root name is identified by @ and should be
-				// translated to zone name for the endpoint entry.
-				if r.Name == "@" {
-					name = zone.Name
-				}
-
-				targets := make([]string, len(r.Data))
-				for i, d := range r.Data {
-					targets[i] = d.(string)
+				ep, err := recordEndpoint(detailZone, r)
+				if err != nil {
+					requestid.Logger(ctx).Warnf("Skipping record %s (%s): %v", recordName(detailZone, r), r.Type, err)
+					continue
 				}
-				ep := endpoint.NewEndpointWithTTL(name, r.Type, endpoint.TTL(r.TTL), targets...)
+				recordCounts[[2]string{detailZone.Name, r.Type}]++
 				endpoints = append(endpoints, ep)
 			}
 		}
 	}
 
-	return endpoints, nil
+	return mergeEndpoints(ctx, endpoints), recordCounts, nil
+}
+
+// setRecordsMetric replaces the record counts of the records metric.
+func setRecordsMetric(recordCounts map[[2]string]int) {
+	metrics.Records.Reset()
+	for zoneAndType, count := range recordCounts {
+		metrics.Records.WithLabelValues(zoneAndType[0], zoneAndType[1]).Set(float64(count))
+	}
+}
+
+// AdjustEndpoints validates the routing-policy properties of the desired endpoints and normalizes
+// them to the form Records reports them in. Invalid endpoints are kept as they are so that
+// ApplyChanges rejects them.
+func (p *BizflyCloudProvider) AdjustEndpoints(endpoints []*endpoint.Endpoint) []*endpoint.Endpoint {
+	return p.AdjustEndpointsContext(context.Background(), endpoints)
+}
+
+// AdjustEndpointsContext adjusts the endpoints like AdjustEndpoints, logging with the request ID of ctx.
+func (p *BizflyCloudProvider) AdjustEndpointsContext(ctx context.Context, endpoints []*endpoint.Endpoint) []*endpoint.Endpoint {
+	for _, ep := range endpoints {
+		if err := normalizePolicyEndpoint(ep); err != nil {
+			requestid.Logger(ctx).WithField("record", ep.DNSName).Errorf("invalid routing policy: %v", err)
+		}
+	}
+	return endpoints
 }
 
 // ApplyChanges applies a given set of changes in a given zone.
+// Changes that fail are collected into a provider.ApplyChangesError while the remaining ones are still applied.
 func (p *BizflyCloudProvider) ApplyChanges(ctx context.Context, changes *plan.Changes) error {
+	applyErr := &provider.ApplyChangesError{}
+	bizflycloudChanges, invalid := p.newBizflyCloudChanges(changes)
+	for _, err := range invalid {
+		metrics.ObserveChange(err.Action, err)
+		applyErr.Add(err)
+	}
 
+	if err := p.submitChanges(ctx, bizflycloudChanges, applyErr); err != nil {
+		return err
+	}
+	return applyErr.ErrorOrNil()
+}
+
+// newBizflyCloudChanges converts the changes of a plan, returning the ones that are invalid as errors.
+func (p *BizflyCloudProvider) newBizflyCloudChanges(changes *plan.Changes) ([]*bizflyCloudChange, []*provider.RecordError) {
 	bizflycloudChanges := []*bizflyCloudChange{}
+	var invalid []*provider.RecordError
 
-	for _, endpoint := range changes.Create {
-		bizflycloudChanges = append(bizflycloudChanges, p.newBizflyCloudChange(bizflyCloudCreate, endpoint))
+	addChanges := func(action string, endpoints []*endpoint.Endpoint) {
+		for _, ep := range endpoints {
+			change, err := p.newBizflyCloudChange(action, ep)
+			if err != nil {
+				invalid = append(invalid, provider.NewRecordError("", ep.DNSName, ep.RecordType, ep.SetIdentifier, action, err))
+				continue
+			}
+			bizflycloudChanges = append(bizflycloudChanges, change)
+		}
 	}
-
-	for _, endpoint := range changes.UpdateNew {
-		bizflycloudChanges = append(bizflycloudChanges, p.newBizflyCloudChange(bizflyCloudUpdate, endpoint))
+	addChanges(bizflyCloudCreate, changes.Create)
+	addChanges(bizflyCloudUpdate, changes.UpdateNew)
+	addChanges(bizflyCloudDelete, changes.Delete)
+
+	// pair every update with the state it was computed against
+	previous := make(map[string]*endpoint.Endpoint, len(changes.UpdateOld))
+	for _, ep := range changes.UpdateOld {
+		previous[endpointKey(ep)] = ep
 	}
-
-	for _, endpoint := range changes.Delete {
-		bizflycloudChanges = append(bizflycloudChanges, p.newBizflyCloudChange(bizflyCloudDelete, endpoint))
+	for _, change := range bizflycloudChanges {
+		if change.Action == bizflyCloudUpdate {
+			change.Previous = previous[change.key()]
+		}
 	}
-	return p.submitChanges(ctx, bizflycloudChanges)
+	return bizflycloudChanges, invalid
 }
 
-// submitChanges takes a zone and a collection of Changes and sends them as a single transaction.
-func (p *BizflyCloudProvider) submitChanges(ctx context.Context, changes []*bizflyCloudChange) error {
+func (p *BizflyCloudProvider) submitChanges(ctx context.Context, changes []*bizflyCloudChange, applyErr *provider.ApplyChangesError) error {
 	// return early if there is nothing to change
 	if len(changes) == 0 {
-		log.Info("All records are already up to date")
+		requestid.Logger(ctx).Info("All records are already up to date")
 		return nil
 	}
 
-	zones, err := p.listDNSZonesWithAutoPagination(ctx)
+	zones, unmatched, err := p.fetchZoneChanges(ctx, changes)
 	if err != nil {
 		return err
 	}
+	// PlanChanges reports these as skipped, they are not errors as external-dns may send names of other providers
+	for _, change := range unmatched {
+		requestid.Logger(ctx).WithFields(log.Fields{"record": change.NormalRecord.Name, "type": change.NormalRecord.Type, "action": change.Action}).
+			Warnf("Skipping record %s: %v", change.NormalRecord.Name, errNoMatchingZone)
+	}
+
+	// changes to the same name are applied in order by a single task, different names run concurrently
+	tasks := []changeTask{}
+	for _, zone := range zones {
+		if zone.err != nil {
+			for _, change := range zone.changes {
+				metrics.ObserveChange(change.Action, zone.err)
+				applyErr.Add(change.recordError(zone.zoneID, zone.err))
+			}
+			continue
+		}
+		tasks = append(tasks, groupChangesByName(zone.zoneID, zone.detailZone, zone.changes)...)
+	}
+
+	taskErrs := make([][]*provider.RecordError, len(tasks))
+	forEachBounded(p.applyConcurrency, len(tasks), func(i int) {
+		taskErrs[i] = p.applyTask(ctx, tasks[i])
+	})
+	// errors are reported in task order regardless of which task finished first
+	for _, errs := range taskErrs {
+		for _, err := range errs {
+			applyErr.Add(err)
+		}
+	}
+	return nil
+}
+
+// zoneChanges holds the changes to a zone together with its records, or the error fetching them.
+type zoneChanges struct {
+	zoneID     string
+	zoneName   string
+	detailZone *gobizfly.ExtendedZone
+	err        error
+	changes    []*bizflyCloudChange
+}
+
+// fetchZoneChanges separates the changes by zone and fetches the records of every affected zone.
+// Zones are ordered by ID, changes matching no zone are returned separately.
+func (p *BizflyCloudProvider) fetchZoneChanges(ctx context.Context, changes []*bizflyCloudChange) ([]zoneChanges, []*bizflyCloudChange, error) {
+	zones, err := p.listDNSZonesWithAutoPagination(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	zoneNames := make(map[string]string, len(zones))
+	for _, z := range zones {
+		zoneNames[z.ID] = z.Name
+	}
 	// separate into per-zone change sets to be passed to the API.
-	groupChangesByZoneID := p.groupChangesByZoneID(zones, changes)
+	groupChangesByZoneID, unmatched := p.groupChangesByZoneID(zones, changes)
 
+	zoneIDs := make([]string, 0, len(groupChangesByZoneID))
 	for zoneID, changes := range groupChangesByZoneID {
-		detailZone, err := p.Client.GetZone(ctx, zoneID)
+		if len(changes) > 0 {
+			zoneIDs = append(zoneIDs, zoneID)
+		}
+	}
+	sort.Strings(zoneIDs)
+
+	result := make([]zoneChanges, len(zoneIDs))
+	forEachBounded(p.applyConcurrency, len(zoneIDs), func(i int) {
+		zoneID := zoneIDs[i]
+		result[i] = zoneChanges{zoneID: zoneID, zoneName: zoneNames[zoneID], changes: groupChangesByZoneID[zoneID]}
+		detailZone, err := p.getZone(ctx, zoneID)
 		if err != nil {
-			return fmt.Errorf("could not fetch records from zone, %v", err)
-		}
-		for _, change := range changes {
-			logFields := log.Fields{
-				"record": change.NormalRecord.Name,
-				"type":   change.NormalRecord.Type,
-				"ttl":    change.NormalRecord.TTL,
-				"action": change.Action,
-				"zone":   zoneID,
-			}
+			result[i].err = fmt.Errorf("could not fetch records from zone, %v", err)
+			return
+		}
+		result[i].detailZone = detailZone
+	})
+	return result, unmatched, nil
+}
 
-			log.WithFields(logFields).Info("Changing record...")
+// changeTask holds the changes to a single name within a zone.
+type changeTask struct {
+	zoneID     string
+	detailZone *gobizfly.ExtendedZone
+	changes    []*bizflyCloudChange
+}
 
-			if p.DryRun {
-				continue
-			}
+// groupChangesByName splits the changes of a zone into one task per record name, ordered by name.
+// Within a task updates come first, then deletes, then creates.
+func groupChangesByName(zoneID string, detailZone *gobizfly.ExtendedZone, changes []*bizflyCloudChange) []changeTask {
+	byName := map[string][]*bizflyCloudChange{}
+	for _, change := range changes {
+		byName[change.NormalRecord.Name] = append(byName[change.NormalRecord.Name], change)
+	}
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tasks := make([]changeTask, 0, len(names))
+	for _, name := range names {
+		changes := byName[name]
+		sort.SliceStable(changes, func(i, j int) bool {
+			return actionOrder[changes[i].Action] < actionOrder[changes[j].Action]
+		})
+		tasks = append(tasks, changeTask{zoneID: zoneID, detailZone: detailZone, changes: changes})
+	}
+	return tasks
+}
 
-			if change.Action == bizflyCloudUpdate {
-				recordID := p.getRecordID(detailZone, change.NormalRecord)
-				if recordID == "" {
-					log.WithFields(logFields).Errorf("failed to find previous record: %v", change.NormalRecord)
-					continue
-				}
-				recordParam := getUpdateDNSRecordParam(*change)
+// actionOrder makes sure a record is removed before it is added again under the same name,
+// while updates still find the record they were computed against.
+var actionOrder = map[string]int{
+	bizflyCloudUpdate: 0,
+	bizflyCloudDelete: 1,
+	bizflyCloudCreate: 2,
+}
 
-				_, err := p.Client.UpdateRecord(ctx, recordID, recordParam)
-				if err != nil {
-					log.WithFields(logFields).Errorf("failed to update record: %v", err)
-				}
-			} else if change.Action == bizflyCloudDelete {
-				recordID := p.getRecordID(detailZone, change.NormalRecord)
-				if recordID == "" {
-					log.WithFields(logFields).Errorf("failed to find previous record: %v", change.NormalRecord)
-					continue
-				}
-				err := p.Client.DeleteRecord(ctx, recordID)
-				if err != nil {
-					log.WithFields(logFields).Errorf("failed to delete record: %v", err)
-				}
-			} else if change.Action == bizflyCloudCreate {
-				recordParam := getCreateDNSRecordParam(*change)
-				_, err := p.Client.CreateRecord(ctx, zoneID, recordParam)
-				if err != nil {
-					log.WithFields(logFields).Errorf("failed to create record: %v", err)
-				}
-			}
+// applyTask applies the changes of a task one after another and returns the failed ones.
+func (p *BizflyCloudProvider) applyTask(ctx context.Context, task changeTask) []*provider.RecordError {
+	var errs []*provider.RecordError
+	for _, change := range task.changes {
+		logger := requestid.Logger(ctx).WithFields(log.Fields{
+			"record": change.NormalRecord.Name,
+			"type":   change.NormalRecord.Type,
+			"ttl":    change.NormalRecord.TTL,
+			"action": change.Action,
+			"zone":   task.zoneID,
+		})
+
+		logger.Info("Changing record...")
+
+		if p.DryRun {
+			p.logPlannedOperations(ctx, logger, task, change)
+			continue
+		}
+
+		err := p.applyChange(ctx, task.zoneID, task.detailZone, change)
+		metrics.ObserveChange(change.Action, err)
+		if err != nil {
+			logger.Errorf("failed to %s record: %v", strings.ToLower(change.Action), err)
+			errs = append(errs, change.recordError(task.zoneID, err))
+		}
+	}
+	return errs
+}
+
+// forEachBounded calls fn for every index in [0, n) with at most limit calls running at the same time.
+func forEachBounded(limit, n int, fn func(i int)) {
+	if limit < 1 {
+		limit = 1
+	}
+	sem := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// applyChange sends the operations needed for a single change to the API.
+func (p *BizflyCloudProvider) applyChange(ctx context.Context, zoneID string, detailZone *gobizfly.ExtendedZone, change *bizflyCloudChange) error {
+	operations, err := p.planChange(ctx, zoneID, detailZone, change)
+	if err != nil {
+		return err
+	}
+	for _, operation := range operations {
+		recordID, err := p.executeTracedOperation(ctx, change, operation)
+		p.auditOperation(ctx, detailZone, change, operation, recordID, err)
+		if err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
+// getZone fetches the records of a zone.
+func (p *BizflyCloudProvider) getZone(ctx context.Context, zoneID string) (zone *gobizfly.ExtendedZone, err error) {
+	ctx, span := tracing.Start(ctx, "bizflycloud.GetZone", attribute.String("zone.id", zoneID))
+	defer func() {
+		if zone != nil {
+			span.SetAttributes(attribute.String("zone.name", zone.Name), attribute.Int("records", len(zone.RecordsSet)))
+		}
+		tracing.End(span, err)
+	}()
+	return p.Client.GetZone(ctx, zoneID)
+}
+
+// executeTracedOperation executes an operation in a span describing the record it changes.
+func (p *BizflyCloudProvider) executeTracedOperation(ctx context.Context, change *bizflyCloudChange, operation recordOperation) (string, error) {
+	ctx, span := tracing.Start(ctx, "bizflycloud."+strings.ToLower(operation.Action)+"Record",
+		attribute.String("zone.id", operation.ZoneID),
+		attribute.String("record.id", operation.RecordID),
+		attribute.String("record.name", change.NormalRecord.Name),
+		attribute.String("record.type", change.NormalRecord.Type),
+		attribute.String("record.set_identifier", change.setIdentifier()),
+		attribute.String("change.action", change.Action))
+	recordID, err := p.executeOperation(ctx, operation)
+	tracing.End(span, err)
+	return recordID, err
+}
+
+// planChange resolves a change into the API operations applying it to the zone.
+func (p *BizflyCloudProvider) planChange(ctx context.Context, zoneID string, detailZone *gobizfly.ExtendedZone, change *bizflyCloudChange) ([]recordOperation, error) {
+	if change.Action == bizflyCloudCreate {
+		return []recordOperation{{Action: bizflyCloudCreate, ZoneID: zoneID, Change: change}}, nil
+	}
+
+	// policy records are unique per set identifier, all other endpoints may be backed by several records
+	records := []gobizfly.Record{}
+	if change.PolicyRecord != nil {
+		if recordID := p.findRecordID(detailZone, change); recordID != "" {
+			records = append(records, gobizfly.Record{ID: recordID})
+		}
+	} else {
+		records = p.getRecords(detailZone, change.NormalRecord)
+		// a record holding exactly the previous state is the one the update was computed against,
+		// the other records of the endpoint are left alone
+		if change.Previous != nil {
+			if recordID := p.getPreviousRecordID(detailZone, change.Previous); recordID != "" {
+				records = recordsWithID(records, recordID)
+			}
+		}
+	}
+
+	if len(records) == 0 {
+		if change.Action == bizflyCloudDelete {
+			return nil, errRecordNotFound
+		}
+		// the record disappeared since external-dns read it, so it is added again
+		requestid.Logger(ctx).WithFields(log.Fields{"record": change.NormalRecord.Name, "type": change.NormalRecord.Type, "zone": zoneID}).
+			Warn("Previous record not found, creating it")
+		return []recordOperation{{Action: bizflyCloudCreate, ZoneID: zoneID, Change: change}}, nil
+	}
+
+	switch {
+	case change.Action == bizflyCloudDelete:
+		operations := make([]recordOperation, 0, len(records))
+		for _, r := range records {
+			operations = append(operations, recordOperation{Action: bizflyCloudDelete, ZoneID: zoneID, RecordID: r.ID})
+		}
+		return operations, nil
+	case change.PolicyRecord != nil:
+		return []recordOperation{{Action: bizflyCloudUpdate, ZoneID: zoneID, RecordID: records[0].ID, Change: change}}, nil
+	default:
+		return reconcileRecords(zoneID, change, records)
+	}
+}
+
+// executeOperation sends a single operation to the API and returns the ID of the changed record.
+func (p *BizflyCloudProvider) executeOperation(ctx context.Context, operation recordOperation) (string, error) {
+	switch operation.Action {
+	case bizflyCloudCreate:
+		record, err := p.Client.CreateRecord(ctx, operation.ZoneID, getCreateDNSRecordParam(*operation.Change))
+		if err != nil || record == nil {
+			return "", err
+		}
+		return record.ID, nil
+	case bizflyCloudUpdate:
+		_, err := p.Client.UpdateRecord(ctx, operation.RecordID, getUpdateDNSRecordParam(*operation.Change))
+		return operation.RecordID, err
+	case bizflyCloudDelete:
+		return operation.RecordID, p.Client.DeleteRecord(ctx, operation.RecordID)
+	default:
+		return "", fmt.Errorf("unknown action %s", operation.Action)
+	}
+}
+
 // groupChangesByZoneID separates a multi-zone change into a single change per zone.
-func (p *BizflyCloudProvider) groupChangesByZoneID(zones []gobizfly.Zone, changeSet []*bizflyCloudChange) map[string][]*bizflyCloudChange {
+// Changes whose name matches no zone passing the zone filter are returned separately.
+func (p *BizflyCloudProvider) groupChangesByZoneID(zones []gobizfly.Zone, changeSet []*bizflyCloudChange) (map[string][]*bizflyCloudChange, []*bizflyCloudChange) {
 	changes := make(map[string][]*bizflyCloudChange)
 	zoneNameIDMapper := provider.ZoneIDName{}
+	excludedZones := provider.ZoneIDName{}
 
 	for _, z := range zones {
+		if !p.zoneFilter.Match(z) {
+			excludedZones.Add(z.ID, z.Name)
+			continue
+		}
 		zoneNameIDMapper.Add(z.ID, z.Name)
 		changes[z.ID] = []*bizflyCloudChange{}
 	}
 
+	var unmatched []*bizflyCloudChange
 	for _, c := range changeSet {
-		zoneID, _ := zoneNameIDMapper.FindZone(c.NormalRecord.Name)
+		zoneID, zoneName := zoneNameIDMapper.FindZone(c.NormalRecord.Name)
+		// a record of an excluded subdomain zone must not end up in the parent zone
+		if _, excludedName := excludedZones.FindZone(c.NormalRecord.Name); len(excludedName) > len(zoneName) {
+			zoneID = ""
+		}
 		if zoneID == "" {
-			log.Debugf("Skipping record %s because no hosted zone matching record DNS Name was detected", c.NormalRecord.Name)
+			unmatched = append(unmatched, c)
 			continue
 		}
 		changes[zoneID] = append(changes[zoneID], c)
 	}
 
-	return changes
+	return changes, unmatched
+}
+
+// findRecordID returns the ID of the Bizfly record a change applies to.
+// Updates prefer the record holding exactly the previous state of the endpoint.
+func (p *BizflyCloudProvider) findRecordID(zone *gobizfly.ExtendedZone, change *bizflyCloudChange) string {
+	if change.Previous != nil {
+		if recordID := p.getPreviousRecordID(zone, change.Previous); recordID != "" {
+			return recordID
+		}
+	}
+	if change.PolicyRecord != nil {
+		return p.getPolicyRecordID(zone, *change.PolicyRecord)
+	}
+	return p.getRecordID(zone, change.NormalRecord)
+}
+
+// getRecords returns all records backing the endpoint of a normal record, in zone order.
+func (p *BizflyCloudProvider) getRecords(zone *gobizfly.ExtendedZone, record NormalRecord) []gobizfly.Record {
+	records := []gobizfly.Record{}
+	for _, zoneRecord := range zone.RecordsSet {
+		if _, ok := routingPolicy(zoneRecord); ok {
+			continue
+		}
+		if recordName(zone, zoneRecord) == record.Name && zoneRecord.Type == record.Type {
+			records = append(records, zoneRecord)
+		}
+	}
+	return records
+}
+
+// recordsWithID keeps the record with the given ID, or all records when none has it.
+func recordsWithID(records []gobizfly.Record, recordID string) []gobizfly.Record {
+	for _, r := range records {
+		if r.ID == recordID {
+			return []gobizfly.Record{r}
+		}
+	}
+	return records
 }
 
 func (p *BizflyCloudProvider) getRecordID(zone *gobizfly.ExtendedZone, record NormalRecord) string {
 	for _, zoneRecord := range zone.RecordsSet {
-		name := zoneRecord.Name + "." + zone.Name
-		if zoneRecord.Name == "@" {
-			name = zone.Name
+		if _, ok := routingPolicy(zoneRecord); ok {
+			continue
+		}
+		if recordName(zone, zoneRecord) == record.Name && zoneRecord.Type == record.Type {
+			return zoneRecord.ID
+		}
+	}
+	return ""
+}
+
+// getPolicyRecordID tells policy records sharing a name and type apart by their routing key,
+// which is derived from the set identifier.
+func (p *BizflyCloudProvider) getPolicyRecordID(zone *gobizfly.ExtendedZone, record PolicyRecord) string {
+	for _, zoneRecord := range zone.RecordsSet {
+		policy, ok := routingPolicy(zoneRecord)
+		if !ok {
+			continue
 		}
-		if name == record.Name && zoneRecord.Type == record.Type {
+		if recordName(zone, zoneRecord) == record.Name && zoneRecord.Type == record.Type && policy.hasRoutingKey(record.routingKey()) {
 			return zoneRecord.ID
 		}
 	}
 	return ""
 }
 
-func (p *BizflyCloudProvider) newBizflyCloudChange(action string, endpoint *endpoint.Endpoint) *bizflyCloudChange {
+// getPreviousRecordID returns the record matching the name, type, set identifier, TTL and targets of previous.
+func (p *BizflyCloudProvider) getPreviousRecordID(zone *gobizfly.ExtendedZone, previous *endpoint.Endpoint) string {
+	for _, zoneRecord := range zone.RecordsSet {
+		if zoneRecord.Type != previous.RecordType || recordName(zone, zoneRecord) != previous.DNSName {
+			continue
+		}
+		ep, err := recordEndpoint(zone, zoneRecord)
+		if err != nil || ep.SetIdentifier != previous.SetIdentifier || !ep.Targets.Same(previous.Targets) {
+			continue
+		}
+		if previous.RecordTTL.IsConfigured() && ep.RecordTTL != previous.RecordTTL {
+			continue
+		}
+		return zoneRecord.ID
+	}
+	return ""
+}
+
+// recordName returns the fully qualified name of a Bizfly record.
+func recordName(zone *gobizfly.ExtendedZone, r gobizfly.Record) string {
+	// root name is identified by @ and should be
+	// translated to zone name for the endpoint entry.
+	if r.Name == "@" {
+		return zone.Name
+	}
+	return r.Name + "." + zone.Name
+}
+
+func (p *BizflyCloudProvider) newBizflyCloudChange(action string, ep *endpoint.Endpoint) (*bizflyCloudChange, error) {
 	ttl := defaultBizflyCloudRecordTTL
 
-	if endpoint.RecordTTL.IsConfigured() {
-		ttl = int(endpoint.RecordTTL)
+	if ep.RecordTTL.IsConfigured() {
+		ttl = int(ep.RecordTTL)
 	}
 
-	return &bizflyCloudChange{
+	change := &bizflyCloudChange{
 		Action: action,
 		NormalRecord: NormalRecord{
-			Name: endpoint.DNSName,
+			Name: ep.DNSName,
 			TTL:  ttl,
-			Type: endpoint.RecordType,
-			Data: endpoint.Targets,
+			Type: ep.RecordType,
+			Data: ep.Targets,
 		},
 	}
+
+	policy, err := newPolicyRecord(ep, ttl)
+	if err != nil {
+		return nil, err
+	}
+	change.PolicyRecord = policy
+
+	if ep.RecordType == endpoint.RecordTypeMX {
+		mx, err := newMXRecord(ep.DNSName, ttl, ep.Targets)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build MX record %s: %w", ep.DNSName, err)
+		}
+		change.MXRecord = mx
+	}
+	return change, nil
+}
+
+// recordEndpoint converts a Bizfly record into an endpoint.
+func recordEndpoint(zone *gobizfly.ExtendedZone, r gobizfly.Record) (*endpoint.Endpoint, error) {
+	name := recordName(zone, r)
+	if policy, ok := routingPolicy(r); ok {
+		return policyEndpoint(name, r, policy)
+	}
+	targets, err := recordTargets(r)
+	if err != nil {
+		return nil, err
+	}
+	ep := endpoint.NewEndpointWithTTL(name, r.Type, endpoint.TTL(r.TTL), targets...)
+	if ep == nil {
+		return nil, errInvalidRecordName
+	}
+	return ep, nil
+}
+
+// recordTargets converts the data of a Bizfly record into external-dns targets.
+func recordTargets(r gobizfly.Record) ([]string, error) {
+	targets := make([]string, len(r.Data))
+	for i, d := range r.Data {
+		if r.Type == endpoint.RecordTypeMX {
+			target, err := mxTarget(d)
+			if err != nil {
+				return nil, err
+			}
+			targets[i] = target
+			continue
+		}
+		target, ok := d.(string)
+		if !ok {
+			return nil, fmt.Errorf("unexpected record data: %v", d)
+		}
+		targets[i] = target
+	}
+	return targets, nil
 }
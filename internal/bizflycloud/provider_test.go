@@ -1,15 +1,40 @@
 package bizflycloud
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/audit"
 	"github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/endpoint"
+	"github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/metrics"
 	"github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/plan"
+	pkgprovider "github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/provider"
+	"github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/requestid"
+	"github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/tracing"
 	"github.com/bizflycloud/gobizfly"
+	"github.com/caarlos0/env/v8"
 	"github.com/maxatome/go-testdeep/td"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 type MockAction struct {
@@ -19,6 +44,7 @@ type MockAction struct {
 }
 
 type mockBizflyCloudClient struct {
+	mu      sync.Mutex
 	Zones   map[string]string
 	Records map[string]gobizfly.Record
 	Actions []MockAction
@@ -82,8 +108,54 @@ func NewMockBizflyCloudClientWithRecords(records []gobizfly.Record) *mockBizflyC
 	return m
 }
 
+// makeMXRecordData mirrors the shape MX data has after being decoded from an API response.
+func makeMXRecordData(listData []gobizfly.MXData) []interface{} {
+	recordData := make([]interface{}, 0)
+	for _, data := range listData {
+		recordData = append(recordData, map[string]interface{}{
+			"value":    data.Value,
+			"priority": float64(data.Priority),
+		})
+	}
+	return recordData
+}
+
 func getDNSRecordFromRecordParams(crpl interface{}, zoneID string, recordID string) gobizfly.Record {
 	switch params := crpl.(type) {
+	case gobizfly.CreateMXRecordPayload:
+		return gobizfly.Record{
+			Name:   params.Name,
+			TTL:    params.TTL,
+			Type:   params.Type,
+			ZoneID: zoneID,
+			Data:   makeMXRecordData(params.Data),
+		}
+	case gobizfly.UpdateMXRecordPayload:
+		return gobizfly.Record{
+			ID:     recordID,
+			Name:   params.Name,
+			TTL:    params.TTL,
+			Type:   params.Type,
+			ZoneID: zoneID,
+			Data:   makeMXRecordData(params.Data),
+		}
+	case CreatePolicyRecordPayload:
+		return gobizfly.Record{
+			Name:   params.Name,
+			TTL:    params.TTL,
+			Type:   params.Type,
+			ZoneID: zoneID,
+			Data:   []interface{}{params.RoutingPolicyData},
+		}
+	case UpdatePolicyRecordPayload:
+		return gobizfly.Record{
+			ID:     recordID,
+			Name:   params.Name,
+			TTL:    params.TTL,
+			Type:   params.Type,
+			ZoneID: zoneID,
+			Data:   []interface{}{params.RoutingPolicyData},
+		}
 	case gobizfly.CreateNormalRecordPayload:
 		return gobizfly.Record{
 			Name:   params.Name,
@@ -107,6 +179,9 @@ func getDNSRecordFromRecordParams(crpl interface{}, zoneID string, recordID stri
 }
 
 func (m *mockBizflyCloudClient) CreateRecord(ctx context.Context, zoneID string, crpl interface{}) (*gobizfly.Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	recordData := getDNSRecordFromRecordParams(crpl, zoneID, "")
 	m.Actions = append(m.Actions, MockAction{
 		Name:       "Create",
@@ -118,6 +193,9 @@ func (m *mockBizflyCloudClient) CreateRecord(ctx context.Context, zoneID string,
 }
 
 func (m *mockBizflyCloudClient) UpdateRecord(ctx context.Context, recordID string, urpl interface{}) (*gobizfly.Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	if record, ok := m.Records[recordID]; ok {
 		zoneID := record.ZoneID
 		recordData := getDNSRecordFromRecordParams(urpl, zoneID, recordID)
@@ -132,6 +210,9 @@ func (m *mockBizflyCloudClient) UpdateRecord(ctx context.Context, recordID strin
 }
 
 func (m *mockBizflyCloudClient) DeleteRecord(ctx context.Context, recordID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	if record, ok := m.Records[recordID]; ok {
 		zoneID := record.ZoneID
 		m.Actions = append(m.Actions, MockAction{
@@ -147,6 +228,9 @@ func (m *mockBizflyCloudClient) DeleteRecord(ctx context.Context, recordID strin
 }
 
 func (m *mockBizflyCloudClient) ListZones(ctx context.Context, opts *gobizfly.ListOptions) (*gobizfly.ListZoneResp, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	result := gobizfly.ListZoneResp{}
 
 	for zoneID, zoneName := range m.Zones {
@@ -160,12 +244,19 @@ func (m *mockBizflyCloudClient) ListZones(ctx context.Context, opts *gobizfly.Li
 }
 
 func (m *mockBizflyCloudClient) GetZone(ctx context.Context, zoneID string) (*gobizfly.ExtendedZone, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	recordSet := []gobizfly.Record{}
 	for _, record := range m.Records {
 		if record.ZoneID == zoneID {
 			recordSet = append(recordSet, record)
 		}
 	}
+	// keep the zone order stable like the API does
+	sort.Slice(recordSet, func(i, j int) bool {
+		return recordSet[i].ID < recordSet[j].ID
+	})
 	for id, zoneName := range m.Zones {
 		if zoneID == id {
 			return &gobizfly.ExtendedZone{
@@ -213,6 +304,129 @@ func TestBizflyCloudZonesWithIDFilter(t *testing.T) {
 	assert.Equal(t, "bar.com", zones[0].Name)
 }
 
+// zoneStateBizflyCloudClient lists zones in the given states, zones without a state are inactive
+type zoneStateBizflyCloudClient struct {
+	bizflyCloudDNS
+	states map[string]string
+}
+
+func (c *zoneStateBizflyCloudClient) ListZones(ctx context.Context, opts *gobizfly.ListOptions) (*gobizfly.ListZoneResp, error) {
+	resp, err := c.bizflyCloudDNS.ListZones(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	for i, zone := range resp.Zones {
+		switch c.states[zone.ID] {
+		case zoneStateActive:
+			resp.Zones[i].Active = true
+		case zoneStateDeleted:
+			resp.Zones[i].Deleted = 1
+		}
+	}
+	return resp, nil
+}
+
+func TestBizflycloudZoneFilter(t *testing.T) {
+	mock := NewMockBizflyCloudClientWithRecords(append([]gobizfly.Record{{
+		ID:     "R010",
+		ZoneID: "Z003",
+		Name:   "www",
+		Type:   endpoint.RecordTypeA,
+		TTL:    60,
+		Data:   makeRecordData([]string{"10.0.0.1"}),
+	}}, ExampleRecrods...))
+	mock.Zones["Z003"] = "staging.bar.com"
+	mock.Zones["Z004"] = "old.foo.com"
+	client := &zoneStateBizflyCloudClient{
+		bizflyCloudDNS: mock,
+		states:         map[string]string{"Z001": zoneStateActive, "Z003": zoneStateActive, "Z004": zoneStateDeleted},
+	}
+	filter, err := newZoneFilter(pkgprovider.NewZoneIDFilter([]string{"Z001", "Z002", "Z004"}), []string{"active", "Inactive"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider := &BizflyCloudProvider{Client: client, zoneFilter: filter}
+
+	// staging.bar.com is excluded by ID, old.foo.com by state
+	records, err := provider.Records(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	td.Cmp(t, records, td.Bag(
+		td.Struct(&endpoint.Endpoint{DNSName: "foobar.bar.com"}, nil),
+		td.Struct(&endpoint.Endpoint{DNSName: "foo.bar.com"}, nil),
+		td.Struct(&endpoint.Endpoint{DNSName: "bar.foo.com"}, nil),
+	))
+
+	changes := &plan.Changes{
+		Create: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("new.bar.com", endpoint.RecordTypeA, "1.2.3.4"),
+			// must not be created in the parent zone bar.com
+			endpoint.NewEndpoint("new.staging.bar.com", endpoint.RecordTypeA, "1.2.3.4"),
+			endpoint.NewEndpoint("new.old.foo.com", endpoint.RecordTypeA, "1.2.3.4"),
+		},
+	}
+	changePlan, err := provider.PlanChanges(context.Background(), changes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	td.Cmp(t, changePlan.Skipped, td.Bag(
+		td.Struct(&pkgprovider.RecordError{Record: "new.staging.bar.com", Reason: errNoMatchingZone.Error()}, nil),
+		td.Struct(&pkgprovider.RecordError{Record: "new.old.foo.com", Reason: errNoMatchingZone.Error()}, nil),
+	))
+
+	if err := provider.ApplyChanges(context.Background(), changes); err != nil {
+		t.Fatalf("should not fail, %s", err)
+	}
+	td.Cmp(t, mock.Actions, []MockAction{{
+		Name:   "Create",
+		ZoneId: "Z001",
+		RecordData: gobizfly.Record{
+			Name:   "new.bar.com",
+			ZoneID: "Z001",
+			Type:   endpoint.RecordTypeA,
+			TTL:    defaultBizflyCloudRecordTTL,
+			Data:   makeRecordData([]string{"1.2.3.4"}),
+		},
+	}})
+
+	_, err = newZoneFilter(pkgprovider.NewZoneIDFilter(nil), []string{"archived"})
+	assert.Error(t, err)
+}
+
+func TestBizflycloudZoneStatuses(t *testing.T) {
+	mock := NewMockBizflyCloudClientWithRecords(ExampleRecrods)
+	mock.Zones["Z003"] = "staging.bar.com"
+	mock.Zones["Z004"] = "old.bar.com"
+	mock.Zones["Z005"] = "example.org"
+	client := &zoneStateBizflyCloudClient{
+		bizflyCloudDNS: mock,
+		states:         map[string]string{"Z001": zoneStateActive, "Z003": zoneStateActive, "Z004": zoneStateDeleted},
+	}
+	filter, err := newZoneFilter(pkgprovider.NewZoneIDFilter([]string{"Z001", "Z002", "Z004", "Z005"}), []string{"active", "inactive"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider := &BizflyCloudProvider{
+		Client:       client,
+		account:      "prod",
+		domainFilter: endpoint.NewDomainFilter([]string{"bar.com", "foo.com"}),
+		zoneFilter:   filter,
+	}
+
+	statuses, err := provider.ZoneStatuses(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	td.Cmp(t, statuses, td.Bag(
+		ZoneStatus{Account: "prod", ID: "Z001", Name: "bar.com", State: zoneStateActive, Records: map[string]int{endpoint.RecordTypeA: 2}},
+		ZoneStatus{Account: "prod", ID: "Z002", Name: "foo.com", State: zoneStateInactive, Records: map[string]int{endpoint.RecordTypeA: 1}},
+		ZoneStatus{Account: "prod", ID: "Z003", Name: "staging.bar.com", State: zoneStateActive, Excluded: ExcludedByZoneIDFilter},
+		ZoneStatus{Account: "prod", ID: "Z004", Name: "old.bar.com", State: zoneStateDeleted, Excluded: ExcludedByZoneStateFilter},
+		ZoneStatus{Account: "prod", ID: "Z005", Name: "example.org", State: zoneStateInactive, Excluded: ExcludedByDomainFilter},
+	))
+}
+
 func TestBizflycloudRecords(t *testing.T) {
 	client := NewMockBizflyCloudClientWithRecords(ExampleRecrods)
 
@@ -231,72 +445,114 @@ func TestBizflycloudRecords(t *testing.T) {
 	assert.Equal(t, 2, len(records))
 }
 
-func TestBizflycloudProvider(t *testing.T) {
-	config := Configuration{
-		APICredentialId:     "e5d084f79fd5407da705f8df97332090",
-		APICredentialSecret: "Fhkp66ClGHPFTnjHQId1RWrUoG14qMIK8IWT4GxURTNLfY2cAkVmpTwyJ-xYsDlEqrS0lqBSGG8DEGZma6OQgQ",
-		Debug:               false,
-		DryRun:              false,
-		Region:              "HN",
-		APIPageSize:         100,
+func TestBizflycloudRecordsLongLabel(t *testing.T) {
+	longLabel := strings.Repeat("a", 64)
+	client := NewMockBizflyCloudClientWithRecords([]gobizfly.Record{
+		{
+			ID:     "R050",
+			ZoneID: "Z001",
+			Name:   longLabel,
+			Type:   endpoint.RecordTypeA,
+			TTL:    300,
+			Data:   makeRecordData([]string{"1.2.3.4"}),
+		},
+		{
+			ID:     "R051",
+			ZoneID: "Z001",
+			Name:   longLabel,
+			Type:   endpoint.RecordTypeA,
+			TTL:    300,
+			Data: []interface{}{RoutingPolicyData{
+				RoutingData: map[string][]string{"HN": {"1.1.1.1"}},
+			}},
+		},
+		{
+			ID:     "R052",
+			ZoneID: "Z001",
+			Name:   "ok",
+			Type:   endpoint.RecordTypeA,
+			TTL:    300,
+			Data:   makeRecordData([]string{"5.6.7.8"}),
+		},
+	})
+	provider := &BizflyCloudProvider{
+		Client:       client,
+		domainFilter: endpoint.NewDomainFilter([]string{"bar.com"}),
 	}
-	_, err := NewBizflyCloudProvider(
-		endpoint.NewDomainFilter([]string{"bar.com"}),
-		&config)
+
+	// records external-dns can not represent are skipped
+	records, err := provider.Records(context.Background())
 	if err != nil {
-		t.Errorf("should not fail, %s", err)
+		t.Fatalf("should not fail, %s", err)
 	}
+	td.Cmp(t, records, []*endpoint.Endpoint{
+		endpoint.NewEndpointWithTTL("ok.bar.com", endpoint.RecordTypeA, 300, "5.6.7.8"),
+	})
+	// and not counted as managed records
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.Records.WithLabelValues("bar.com", endpoint.RecordTypeA)))
 
-	emptyConfig := Configuration{}
-	_, err = NewBizflyCloudProvider(
-		endpoint.NewDomainFilter([]string{"bar.com"}),
-		&emptyConfig)
-	if err == nil {
-		t.Errorf("expected to fail")
+	zone, err := client.GetZone(context.Background(), "Z001")
+	if err != nil {
+		t.Fatalf("should not fail, %s", err)
+	}
+	for _, r := range zone.RecordsSet[:2] {
+		_, err := recordEndpoint(zone, r)
+		assert.ErrorIs(t, err, errInvalidRecordName)
 	}
-
 }
 
-func TestBizflycloudApplyChanges(t *testing.T) {
-	changes := &plan.Changes{}
-	client := NewMockBizflyCloudClientWithRecords(ExampleRecrods)
+func TestBizflycloudMXRecords(t *testing.T) {
+	client := NewMockBizflyCloudClientWithRecords([]gobizfly.Record{
+		{
+			ID:     "R010",
+			ZoneID: "Z001",
+			Name:   "@",
+			Type:   endpoint.RecordTypeMX,
+			TTL:    300,
+			Data: makeMXRecordData([]gobizfly.MXData{
+				{Value: "mx1.bar.com", Priority: 10},
+				{Value: "mx2.bar.com.", Priority: 20},
+			}),
+		},
+	})
+	provider := &BizflyCloudProvider{
+		Client:       client,
+		domainFilter: endpoint.NewDomainFilter([]string{"bar.com"}),
+	}
+
+	records, err := provider.Records(context.Background())
+	if err != nil {
+		t.Fatalf("should not fail, %s", err)
+	}
+
+	var mx *endpoint.Endpoint
+	for _, r := range records {
+		if r.RecordType == endpoint.RecordTypeMX {
+			mx = r
+		}
+	}
+	if assert.NotNil(t, mx) {
+		assert.Equal(t, "bar.com", mx.DNSName)
+		assert.Equal(t, endpoint.Targets{"10 mx1.bar.com", "20 mx2.bar.com"}, mx.Targets)
+	}
+}
 
+func TestBizflycloudApplyChangesMX(t *testing.T) {
+	client := NewMockBizflyCloudClient()
 	provider := &BizflyCloudProvider{
 		Client: client,
 	}
 
-	changes.Create = []*endpoint.Endpoint{{
-		DNSName:    "new.bar.com",
-		RecordTTL:  60,
-		RecordType: "A",
-		Targets:    endpoint.Targets{"target1", "target2"},
-	}, {
-		DNSName:    "new.ext-dns-test.unrelated.to",
-		RecordTTL:  60,
-		RecordType: "A",
-		Targets:    endpoint.Targets{"target"},
-	}}
-	changes.Delete = []*endpoint.Endpoint{{
-		DNSName:    "foobar.bar.com",
-		RecordTTL:  60,
-		RecordType: "A",
-		Targets:    endpoint.Targets{"target"},
-	}}
-	changes.UpdateOld = []*endpoint.Endpoint{{
-		DNSName:    "foobar.bar.com",
-		RecordTTL:  60,
-		RecordType: "A",
-		Targets:    endpoint.Targets{"target-old"},
-	}}
-	changes.UpdateNew = []*endpoint.Endpoint{{
-		DNSName:    "foobar.bar.com",
-		RecordTTL:  60,
-		RecordType: "A",
-		Targets:    endpoint.Targets{"target-new"},
-	}}
-	err := provider.ApplyChanges(context.Background(), changes)
+	err := provider.ApplyChanges(context.Background(), &plan.Changes{
+		Create: []*endpoint.Endpoint{{
+			DNSName:    "bar.com",
+			RecordTTL:  300,
+			RecordType: endpoint.RecordTypeMX,
+			Targets:    endpoint.Targets{"10 mx1.bar.com", "20 mx2.bar.com."},
+		}},
+	})
 	if err != nil {
-		t.Errorf("should not fail, %s", err)
+		t.Fatalf("should not fail, %s", err)
 	}
 
 	td.Cmp(t, client.Actions, []MockAction{
@@ -304,44 +560,1520 @@ func TestBizflycloudApplyChanges(t *testing.T) {
 			Name:   "Create",
 			ZoneId: "Z001",
 			RecordData: gobizfly.Record{
-				Name:   "new.bar.com",
+				Name:   "bar.com",
 				ZoneID: "Z001",
-				Type:   "A",
-				TTL:    60,
-				Data:   makeRecordData(endpoint.Targets{"target1", "target2"}),
+				Type:   endpoint.RecordTypeMX,
+				TTL:    300,
+				Data: makeMXRecordData([]gobizfly.MXData{
+					{Value: "mx1.bar.com", Priority: 10},
+					{Value: "mx2.bar.com", Priority: 20},
+				}),
 			},
 		},
+	})
+
+	err = provider.ApplyChanges(context.Background(), &plan.Changes{
+		Create: []*endpoint.Endpoint{{
+			DNSName:    "bar.com",
+			RecordType: endpoint.RecordTypeMX,
+			Targets:    endpoint.Targets{"mx1.bar.com"},
+		}},
+	})
+	if err == nil {
+		t.Errorf("expected to fail")
+	}
+}
+
+var ExamplePolicyRecords = []gobizfly.Record{
+	{
+		ID:     "R020",
+		ZoneID: "Z001",
+		Name:   "geo",
+		Type:   endpoint.RecordTypeA,
+		TTL:    60,
+		Data: []interface{}{RoutingPolicyData{
+			RoutingData: map[string][]string{"HN": {"1.1.1.1"}},
+		}},
+	},
+	{
+		ID:     "R021",
+		ZoneID: "Z001",
+		Name:   "geo",
+		Type:   endpoint.RecordTypeA,
+		TTL:    60,
+		Data: []interface{}{RoutingPolicyData{
+			RoutingData: map[string][]string{"HCM": {"2.2.2.2"}},
+		}},
+	},
+}
+
+func TestBizflycloudPolicyRecords(t *testing.T) {
+	client := NewMockBizflyCloudClientWithRecords(ExamplePolicyRecords)
+	provider := &BizflyCloudProvider{
+		Client:       client,
+		domainFilter: endpoint.NewDomainFilter([]string{"bar.com"}),
+	}
+
+	records, err := provider.Records(context.Background())
+	if err != nil {
+		t.Fatalf("should not fail, %s", err)
+	}
+
+	bySetIdentifier := map[string]*endpoint.Endpoint{}
+	for _, r := range records {
+		if r.SetIdentifier != "" {
+			bySetIdentifier[r.SetIdentifier] = r
+		}
+	}
+	td.Cmp(t, bySetIdentifier, map[string]*endpoint.Endpoint{
+		"HN": endpoint.NewEndpointWithTTL("geo.bar.com", endpoint.RecordTypeA, 60, "1.1.1.1").
+			WithSetIdentifier("HN").
+			WithProviderSpecific(providerSpecificRoutingRegion, "HN"),
+		"HCM": endpoint.NewEndpointWithTTL("geo.bar.com", endpoint.RecordTypeA, 60, "2.2.2.2").
+			WithSetIdentifier("HCM").
+			WithProviderSpecific(providerSpecificRoutingRegion, "HCM"),
+	})
+}
+
+func TestBizflycloudApplyChangesPolicy(t *testing.T) {
+	client := NewMockBizflyCloudClientWithRecords(ExamplePolicyRecords)
+	provider := &BizflyCloudProvider{
+		Client: client,
+	}
+
+	err := provider.ApplyChanges(context.Background(), &plan.Changes{
+		UpdateNew: []*endpoint.Endpoint{
+			endpoint.NewEndpointWithTTL("geo.bar.com", endpoint.RecordTypeA, 60, "3.3.3.3").
+				WithSetIdentifier("HCM").
+				WithProviderSpecific(providerSpecificRoutingRegion, "hcm"),
+		},
+		Delete: []*endpoint.Endpoint{
+			endpoint.NewEndpointWithTTL("geo.bar.com", endpoint.RecordTypeA, 60, "1.1.1.1").
+				WithSetIdentifier("HN").
+				WithProviderSpecific(providerSpecificRoutingRegion, "HN"),
+		},
+	})
+	if err != nil {
+		t.Fatalf("should not fail, %s", err)
+	}
+
+	td.Cmp(t, client.Actions, []MockAction{
 		{
 			Name:   "Update",
 			ZoneId: "Z001",
 			RecordData: gobizfly.Record{
-				Name:   "foobar.bar.com",
+				ID:     "R021",
+				Name:   "geo.bar.com",
 				ZoneID: "Z001",
-				Type:   "A",
+				Type:   endpoint.RecordTypeA,
 				TTL:    60,
-				ID:     "R001",
-				Data:   makeRecordData([]string{"target-new"}),
+				Data: []interface{}{RoutingPolicyData{
+					RoutingData: map[string][]string{"HCM": {"3.3.3.3"}},
+				}},
 			},
 		},
 		{
 			Name:   "Delete",
 			ZoneId: "Z001",
 			RecordData: gobizfly.Record{
-				ID: "R001",
+				ID: "R020",
 			},
 		},
 	})
 
-	// empty changes
-	changes.Create = []*endpoint.Endpoint{}
-	changes.Delete = []*endpoint.Endpoint{}
-	changes.UpdateOld = []*endpoint.Endpoint{}
-	changes.UpdateNew = []*endpoint.Endpoint{}
+	err = provider.ApplyChanges(context.Background(), &plan.Changes{
+		Create: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("geo.bar.com", endpoint.RecordTypeA, "1.1.1.1").
+				WithSetIdentifier("hanoi").
+				WithProviderSpecific(providerSpecificRoutingRegion, "HN"),
+		},
+	})
+	if err == nil {
+		t.Errorf("expected to fail")
+	}
+}
 
-	err = provider.ApplyChanges(context.Background(), changes)
+func TestBizflycloudLowerCaseSetIdentifier(t *testing.T) {
+	client := NewMockBizflyCloudClient()
+	provider := &BizflyCloudProvider{
+		Client: client,
+	}
+	lowerCase := func() *endpoint.Endpoint {
+		return endpoint.NewEndpointWithTTL("geo.bar.com", endpoint.RecordTypeA, 60, "1.1.1.1").
+			WithSetIdentifier("hn").
+			WithProviderSpecific(providerSpecificRoutingRegion, "hn")
+	}
+
+	// the set identifier is normalized like the region it is read back from
+	td.Cmp(t, provider.AdjustEndpoints([]*endpoint.Endpoint{lowerCase()}), []*endpoint.Endpoint{
+		endpoint.NewEndpointWithTTL("geo.bar.com", endpoint.RecordTypeA, 60, "1.1.1.1").
+			WithSetIdentifier("HN").
+			WithProviderSpecific(providerSpecificRoutingRegion, "HN"),
+	})
+
+	// and accepted as it is by ApplyChanges
+	err := provider.ApplyChanges(context.Background(), &plan.Changes{
+		Create: []*endpoint.Endpoint{lowerCase()},
+	})
 	if err != nil {
-		t.Errorf("should not fail, %s", err)
+		t.Fatalf("should not fail, %s", err)
 	}
+	td.Cmp(t, client.Actions, []MockAction{
+		{
+			Name:   "Create",
+			ZoneId: "Z001",
+			RecordData: gobizfly.Record{
+				Name:   "geo.bar.com",
+				ZoneID: "Z001",
+				Type:   endpoint.RecordTypeA,
+				TTL:    60,
+				Data: []interface{}{RoutingPolicyData{
+					RoutingData: map[string][]string{"HN": {"1.1.1.1"}},
+				}},
+			},
+		},
+	})
+}
+
+func TestBizflycloudWeightedRecords(t *testing.T) {
+	client := NewMockBizflyCloudClientWithRecords([]gobizfly.Record{
+		{
+			ID:     "R030",
+			ZoneID: "Z001",
+			Name:   "app",
+			Type:   endpoint.RecordTypeA,
+			TTL:    60,
+			Data: []interface{}{RoutingPolicyData{
+				RoutingData: map[string][]string{"stable": {"1.1.1.1"}},
+				Weights:     map[string]int{"stable": 90},
+			}},
+		},
+		{
+			ID:     "R031",
+			ZoneID: "Z001",
+			Name:   "app",
+			Type:   endpoint.RecordTypeA,
+			TTL:    60,
+			Data: []interface{}{RoutingPolicyData{
+				RoutingData: map[string][]string{"canary": {"2.2.2.2"}},
+				Weights:     map[string]int{"canary": 10},
+			}},
+		},
+	})
+	provider := &BizflyCloudProvider{
+		Client:       client,
+		domainFilter: endpoint.NewDomainFilter([]string{"bar.com"}),
+	}
+
+	records, err := provider.Records(context.Background())
+	if err != nil {
+		t.Fatalf("should not fail, %s", err)
+	}
+	canary := endpoint.NewEndpointWithTTL("app.bar.com", endpoint.RecordTypeA, 60, "2.2.2.2").
+		WithSetIdentifier("canary").
+		WithProviderSpecific(providerSpecificWeight, "10")
+	td.Cmp(t, records, td.SuperBagOf(canary))
+
+	err = provider.ApplyChanges(context.Background(), &plan.Changes{
+		UpdateNew: []*endpoint.Endpoint{
+			endpoint.NewEndpointWithTTL("app.bar.com", endpoint.RecordTypeA, 60, "2.2.2.2").
+				WithSetIdentifier("canary").
+				WithProviderSpecific(providerSpecificWeight, "50"),
+		},
+	})
+	if err != nil {
+		t.Fatalf("should not fail, %s", err)
+	}
+	td.Cmp(t, client.Actions, []MockAction{
+		{
+			Name:   "Update",
+			ZoneId: "Z001",
+			RecordData: gobizfly.Record{
+				ID:     "R031",
+				Name:   "app.bar.com",
+				ZoneID: "Z001",
+				Type:   endpoint.RecordTypeA,
+				TTL:    60,
+				Data: []interface{}{RoutingPolicyData{
+					RoutingData: map[string][]string{"canary": {"2.2.2.2"}},
+					Weights:     map[string]int{"canary": 50},
+				}},
+			},
+		},
+	})
+
+	for _, ep := range []*endpoint.Endpoint{
+		endpoint.NewEndpoint("app.bar.com", endpoint.RecordTypeA, "2.2.2.2").
+			WithProviderSpecific(providerSpecificWeight, "10"),
+		endpoint.NewEndpoint("app.bar.com", endpoint.RecordTypeA, "2.2.2.2").
+			WithSetIdentifier("canary").
+			WithProviderSpecific(providerSpecificWeight, "heavy"),
+	} {
+		err = provider.ApplyChanges(context.Background(), &plan.Changes{Create: []*endpoint.Endpoint{ep}})
+		if err == nil {
+			t.Errorf("expected %s to fail", ep)
+		}
+	}
+}
+
+func TestBizflycloudAdjustEndpoints(t *testing.T) {
+	provider := &BizflyCloudProvider{}
+
+	endpoints := provider.AdjustEndpoints([]*endpoint.Endpoint{
+		endpoint.NewEndpoint("geo.bar.com", endpoint.RecordTypeA, "1.1.1.1").
+			WithProviderSpecific(providerSpecificRoutingRegion, "hn").
+			WithProviderSpecific(providerSpecificHealthCheckProtocol, "https"),
+		endpoint.NewEndpoint("tcp.bar.com", endpoint.RecordTypeA, "1.1.1.1").
+			WithProviderSpecific(providerSpecificHealthCheckProtocol, "tcp"),
+	})
+
+	td.Cmp(t, endpoints[0], endpoint.NewEndpoint("geo.bar.com", endpoint.RecordTypeA, "1.1.1.1").
+		WithSetIdentifier("HN").
+		WithProviderSpecific(providerSpecificRoutingRegion, "HN").
+		WithProviderSpecific(providerSpecificHealthCheckProtocol, "HTTPS").
+		WithProviderSpecific(providerSpecificHealthCheckPort, "443").
+		WithProviderSpecific(providerSpecificHealthCheckPath, "/").
+		WithProviderSpecific(providerSpecificHealthCheckInterval, "30"))
+
+	// TCP health checks need a port, the invalid endpoint is passed through unchanged
+	td.Cmp(t, endpoints[1], endpoint.NewEndpoint("tcp.bar.com", endpoint.RecordTypeA, "1.1.1.1").
+		WithProviderSpecific(providerSpecificHealthCheckProtocol, "tcp"))
+}
+
+func TestBizflycloudHealthCheckedRecords(t *testing.T) {
+	client := NewMockBizflyCloudClient()
+	provider := &BizflyCloudProvider{
+		Client:       client,
+		domainFilter: endpoint.NewDomainFilter([]string{"bar.com"}),
+	}
+
+	desired := provider.AdjustEndpoints([]*endpoint.Endpoint{
+		endpoint.NewEndpointWithTTL("ingress.bar.com", endpoint.RecordTypeA, 60, "1.1.1.1", "2.2.2.2").
+			WithProviderSpecific(providerSpecificHealthCheckPath, "/healthz").
+			WithProviderSpecific(providerSpecificHealthCheckInterval, "15"),
+	})
+	err := provider.ApplyChanges(context.Background(), &plan.Changes{Create: desired})
+	if err != nil {
+		t.Fatalf("should not fail, %s", err)
+	}
+
+	td.Cmp(t, client.Actions[0].RecordData.Data, []interface{}{RoutingPolicyData{
+		RoutingData: map[string][]string{defaultRoutingKey: {"1.1.1.1", "2.2.2.2"}},
+		HealthCheck: &HealthCheck{
+			Protocol: "HTTP",
+			Port:     80,
+			Path:     "/healthz",
+			Interval: 15,
+		},
+	}})
+
+	records, err := provider.Records(context.Background())
+	if err != nil {
+		t.Fatalf("should not fail, %s", err)
+	}
+	for _, r := range records {
+		if r.DNSName != "ingress.bar.com" {
+			continue
+		}
+		assert.Empty(t, r.SetIdentifier)
+		for _, property := range desired[0].ProviderSpecific {
+			actual, ok := r.GetProviderSpecificProperty(property.Name)
+			assert.True(t, ok, property.Name)
+			assert.Equal(t, property.Value, actual.Value, property.Name)
+		}
+		assert.Equal(t, len(desired[0].ProviderSpecific), len(r.ProviderSpecific))
+	}
+}
+
+func TestBizflycloudProvider(t *testing.T) {
+	config := Configuration{
+		APICredentialId:     "e5d084f79fd5407da705f8df97332090",
+		APICredentialSecret: "Fhkp66ClGHPFTnjHQId1RWrUoG14qMIK8IWT4GxURTNLfY2cAkVmpTwyJ-xYsDlEqrS0lqBSGG8DEGZma6OQgQ",
+		Debug:               false,
+		DryRun:              false,
+		Region:              "HN",
+		APIPageSize:         100,
+	}
+	_, err := NewBizflyCloudProvider(
+		endpoint.NewDomainFilter([]string{"bar.com"}),
+		pkgprovider.NewZoneIDFilter(nil),
+		&config)
+	if err != nil {
+		t.Errorf("should not fail, %s", err)
+	}
+
+	emptyConfig := Configuration{}
+	_, err = NewBizflyCloudProvider(
+		endpoint.NewDomainFilter([]string{"bar.com"}),
+		pkgprovider.NewZoneIDFilter(nil),
+		&emptyConfig)
+	if err == nil {
+		t.Errorf("expected to fail")
+	}
+
+}
+
+func TestBizflycloudMultipleAccounts(t *testing.T) {
+	prod := NewMockBizflyCloudClientWithRecords(ExampleRecrods)
+	staging := &mockBizflyCloudClient{
+		Zones: map[string]string{"Z101": "staging.bar.com"},
+		Records: map[string]gobizfly.Record{
+			"R101": {ID: "R101", ZoneID: "Z101", Name: "www", Type: endpoint.RecordTypeA, TTL: 60, Data: makeRecordData([]string{"10.0.0.1"})},
+		},
+	}
+	accounts := []*BizflyCloudProvider{
+		{Client: prod, account: "prod"},
+		{Client: staging, account: "staging"},
+	}
+	provider, err := newMultiAccountProvider(context.Background(), endpoint.NewDomainFilter(nil), accounts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := provider.Records(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	td.Cmp(t, records, td.Bag(
+		td.Struct(&endpoint.Endpoint{DNSName: "foobar.bar.com"}, nil),
+		td.Struct(&endpoint.Endpoint{DNSName: "foo.bar.com"}, nil),
+		td.Struct(&endpoint.Endpoint{DNSName: "bar.foo.com"}, nil),
+		td.Struct(&endpoint.Endpoint{DNSName: "www.staging.bar.com"}, nil),
+	))
+
+	changes := &plan.Changes{
+		Create: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("new.bar.com", endpoint.RecordTypeA, "1.2.3.4"),
+			endpoint.NewEndpoint("new.staging.bar.com", endpoint.RecordTypeA, "1.2.3.4"),
+			endpoint.NewEndpoint("new.unrelated.to", endpoint.RecordTypeA, "1.2.3.4"),
+		},
+		Delete: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("www.staging.bar.com", endpoint.RecordTypeA, "10.0.0.1"),
+		},
+	}
+	changePlan, err := provider.PlanChanges(context.Background(), changes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	td.Cmp(t, changePlan.Zones, td.Bag(
+		td.Struct(&pkgprovider.ZonePlan{ID: "Z001", Name: "bar.com"}, td.StructFields{"Operations": td.Len(1)}),
+		td.Struct(&pkgprovider.ZonePlan{ID: "Z101", Name: "staging.bar.com"}, td.StructFields{"Operations": td.Len(2)}),
+	))
+	td.Cmp(t, changePlan.Skipped, []*pkgprovider.RecordError{
+		pkgprovider.NewRecordError("", "new.unrelated.to", endpoint.RecordTypeA, "", bizflyCloudCreate, errNoMatchingZone),
+	})
+
+	// every change is applied by the account owning its zone, even if a parent zone belongs to another one
+	if err := provider.ApplyChanges(context.Background(), changes); err != nil {
+		t.Fatalf("should not fail, %s", err)
+	}
+	td.Cmp(t, prod.Actions, td.Bag(
+		td.Struct(MockAction{Name: "Create", ZoneId: "Z001"}, nil),
+	))
+	td.Cmp(t, staging.Actions, td.Bag(
+		td.Struct(MockAction{Name: "Delete", ZoneId: "Z101"}, nil),
+		td.Struct(MockAction{Name: "Create", ZoneId: "Z101"}, nil),
+	))
+
+	// a zone served by two accounts is rejected
+	staging.Zones["Z102"] = "bar.com"
+	err = provider.ApplyChanges(context.Background(), changes)
+	assert.ErrorContains(t, err, "zone bar.com is served by accounts prod and staging")
+	_, err = newMultiAccountProvider(context.Background(), endpoint.NewDomainFilter(nil), accounts)
+	assert.Error(t, err)
+}
+
+func TestBizflycloudMultipleAccountsPartialFailure(t *testing.T) {
+	prod := &flakyBizflyCloudClient{
+		bizflyCloudDNS: NewMockBizflyCloudClientWithRecords(ExampleRecrods),
+		failures:       map[string][]error{},
+		calls:          map[string]int{},
+	}
+	staging := &mockBizflyCloudClient{
+		Zones:   map[string]string{"Z101": "staging.bar.com"},
+		Records: map[string]gobizfly.Record{},
+	}
+	provider, err := newMultiAccountProvider(context.Background(), endpoint.NewDomainFilter(nil), []*BizflyCloudProvider{
+		{Client: prod, account: "prod"},
+		{Client: staging, account: "staging"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the zones of prod can be listed to route the changes, but not to apply them
+	prod.failures["ListZones"] = []error{nil, gobizfly.ErrPermissionDenied}
+
+	err = provider.ApplyChanges(context.Background(), &plan.Changes{
+		Create: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("new.bar.com", endpoint.RecordTypeA, "1.2.3.4"),
+			endpoint.NewEndpoint("new.staging.bar.com", endpoint.RecordTypeA, "1.2.3.4"),
+		},
+		Delete: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("missing.staging.bar.com", endpoint.RecordTypeA, "1.2.3.4"),
+		},
+	})
+
+	// staging is applied after prod failed, and both the failure of prod and the failed record are reported
+	assert.ErrorIs(t, err, gobizfly.ErrPermissionDenied)
+	assert.ErrorContains(t, err, "account prod: ")
+	var applyErr *pkgprovider.ApplyChangesError
+	if assert.ErrorAs(t, err, &applyErr) {
+		td.Cmp(t, applyErr.Errors, td.Bag(
+			td.Struct(&pkgprovider.RecordError{Record: "missing.staging.bar.com", Err: errRecordNotFound}, nil),
+		))
+	}
+	td.Cmp(t, staging.Actions, td.Bag(
+		td.Struct(MockAction{Name: "Create", ZoneId: "Z101"}, nil),
+	))
+}
+
+func TestBizflycloudAccountsConfiguration(t *testing.T) {
+	var config Configuration
+	err := env.ParseWithOptions(&config, env.Options{Environment: map[string]string{
+		"BFC_ACCOUNTS": `[{"name": "prod", "credentialId": "id1", "credentialSecret": "secret1", "projectId": "p1"},
+			{"name": "staging", "credentialId": "id2", "credentialSecret": "secret2", "region": "HCM", "domainFilter": ["staging.bar.com"]}]`,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	accounts, err := config.accounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []Account{
+		{Name: "prod", CredentialID: "id1", CredentialSecret: "secret1", ProjectID: "p1", Region: "HN"},
+		{Name: "staging", CredentialID: "id2", CredentialSecret: "secret2", Region: "HCM", DomainFilter: []string{"staging.bar.com"}},
+	}, accounts)
+
+	// a single account is taken from the credential variables
+	accounts, err = (&Configuration{APICredentialId: "id", APICredentialSecret: "secret", Region: "HN"}).accounts()
+	assert.NoError(t, err)
+	assert.Equal(t, []Account{{CredentialID: "id", CredentialSecret: "secret", Region: "HN"}}, accounts)
+
+	// credentials are read from files
+	secretFile := filepath.Join(t.TempDir(), "secret")
+	require.NoError(t, os.WriteFile(secretFile, []byte("secret\n"), 0o600))
+	accounts, err = (&Configuration{APICredentialId: "id", APICredentialSecretFile: secretFile}).accounts()
+	assert.NoError(t, err)
+	assert.Equal(t, []Account{{CredentialID: "id", CredentialSecret: "secret", CredentialSecretFile: secretFile}}, accounts)
+
+	for name, config := range map[string]Configuration{
+		"no credentials":       {},
+		"both kinds":           {APICredentialId: "id", APICredentialSecret: "secret", Accounts: Accounts{{Name: "a", CredentialID: "id", CredentialSecret: "secret"}}},
+		"account without name": {Accounts: Accounts{{CredentialID: "id", CredentialSecret: "secret"}}},
+		"duplicate account":    {Accounts: Accounts{{Name: "a", CredentialID: "id", CredentialSecret: "secret"}, {Name: "a", CredentialID: "id2", CredentialSecret: "secret2"}}},
+		"missing secret":       {Accounts: Accounts{{Name: "a", CredentialID: "id"}}},
+		"secret and file":      {APICredentialId: "id", APICredentialSecret: "secret", APICredentialSecretFile: secretFile},
+		"missing file":         {Accounts: Accounts{{Name: "a", CredentialID: "id", CredentialSecretFile: secretFile + ".missing"}}},
+	} {
+		if _, err := config.accounts(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestBizflycloudApplyChanges(t *testing.T) {
+	changes := &plan.Changes{}
+	client := NewMockBizflyCloudClientWithRecords(ExampleRecrods)
+
+	provider := &BizflyCloudProvider{
+		Client: client,
+	}
+
+	changes.Create = []*endpoint.Endpoint{{
+		DNSName:    "new.bar.com",
+		RecordTTL:  60,
+		RecordType: "A",
+		Targets:    endpoint.Targets{"target1", "target2"},
+	}, {
+		DNSName:    "new.ext-dns-test.unrelated.to",
+		RecordTTL:  60,
+		RecordType: "A",
+		Targets:    endpoint.Targets{"target"},
+	}}
+	changes.Delete = []*endpoint.Endpoint{{
+		DNSName:    "foobar.bar.com",
+		RecordTTL:  60,
+		RecordType: "A",
+		Targets:    endpoint.Targets{"target"},
+	}}
+	changes.UpdateOld = []*endpoint.Endpoint{{
+		DNSName:    "foobar.bar.com",
+		RecordTTL:  60,
+		RecordType: "A",
+		Targets:    endpoint.Targets{"target-old"},
+	}}
+	changes.UpdateNew = []*endpoint.Endpoint{{
+		DNSName:    "foobar.bar.com",
+		RecordTTL:  60,
+		RecordType: "A",
+		Targets:    endpoint.Targets{"target-new"},
+	}}
+	err := provider.ApplyChanges(context.Background(), changes)
+	if err != nil {
+		t.Errorf("should not fail, %s", err)
+	}
+
+	// changes are applied per name in name order
+	td.Cmp(t, client.Actions, []MockAction{
+		{
+			Name:   "Update",
+			ZoneId: "Z001",
+			RecordData: gobizfly.Record{
+				Name:   "foobar.bar.com",
+				ZoneID: "Z001",
+				Type:   "A",
+				TTL:    60,
+				ID:     "R001",
+				Data:   makeRecordData([]string{"target-new"}),
+			},
+		},
+		{
+			Name:   "Delete",
+			ZoneId: "Z001",
+			RecordData: gobizfly.Record{
+				ID: "R001",
+			},
+		},
+		{
+			Name:   "Create",
+			ZoneId: "Z001",
+			RecordData: gobizfly.Record{
+				Name:   "new.bar.com",
+				ZoneID: "Z001",
+				Type:   "A",
+				TTL:    60,
+				Data:   makeRecordData(endpoint.Targets{"target1", "target2"}),
+			},
+		},
+	})
+
+	// empty changes
+	changes.Create = []*endpoint.Endpoint{}
+	changes.Delete = []*endpoint.Endpoint{}
+	changes.UpdateOld = []*endpoint.Endpoint{}
+	changes.UpdateNew = []*endpoint.Endpoint{}
+
+	err = provider.ApplyChanges(context.Background(), changes)
+	if err != nil {
+		t.Errorf("should not fail, %s", err)
+	}
+}
+
+func TestBizflycloudApplyChangesFailures(t *testing.T) {
+	client := NewMockBizflyCloudClientWithRecords(ExampleRecrods)
+	provider := &BizflyCloudProvider{
+		Client: client,
+	}
+
+	err := provider.ApplyChanges(context.Background(), &plan.Changes{
+		Create: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("new.bar.com", endpoint.RecordTypeA, "1.2.3.4"),
+			endpoint.NewEndpoint("bar.com", endpoint.RecordTypeMX, "mx.bar.com"),
+		},
+		Delete: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("missing.bar.com", endpoint.RecordTypeA, "1.2.3.4"),
+		},
+	})
+
+	var applyErr *pkgprovider.ApplyChangesError
+	if !errors.As(err, &applyErr) {
+		t.Fatalf("expected an ApplyChangesError, got %v", err)
+	}
+	td.Cmp(t, applyErr.Errors, td.Bag(
+		td.Struct(&pkgprovider.RecordError{
+			Record: "bar.com",
+			Type:   endpoint.RecordTypeMX,
+			Action: bizflyCloudCreate,
+		}, td.StructFields{
+			"Reason": td.Contains("invalid MX target"),
+			"Err":    td.NotNil(),
+		}),
+		&pkgprovider.RecordError{
+			Zone:   "Z001",
+			Record: "missing.bar.com",
+			Type:   endpoint.RecordTypeA,
+			Action: bizflyCloudDelete,
+			Reason: errRecordNotFound.Error(),
+			Err:    errRecordNotFound,
+		},
+	))
+
+	// the valid change is applied regardless of the failures
+	td.Cmp(t, client.Actions, td.Len(1))
+	assert.Equal(t, "Create", client.Actions[0].Name)
+}
+
+func TestBizflycloudApplyChangesUpdateOld(t *testing.T) {
+	client := NewMockBizflyCloudClientWithRecords([]gobizfly.Record{
+		{
+			ID:     "R040",
+			ZoneID: "Z001",
+			Name:   "txt",
+			Type:   endpoint.RecordTypeTXT,
+			TTL:    300,
+			Data:   makeRecordData([]string{"first"}),
+		},
+		{
+			ID:     "R041",
+			ZoneID: "Z001",
+			Name:   "txt",
+			Type:   endpoint.RecordTypeTXT,
+			TTL:    300,
+			Data:   makeRecordData([]string{"second"}),
+		},
+	})
+	provider := &BizflyCloudProvider{
+		Client: client,
+	}
+
+	err := provider.ApplyChanges(context.Background(), &plan.Changes{
+		UpdateOld: []*endpoint.Endpoint{
+			endpoint.NewEndpointWithTTL("txt.bar.com", endpoint.RecordTypeTXT, 300, "second"),
+			endpoint.NewEndpointWithTTL("gone.bar.com", endpoint.RecordTypeA, 300, "1.2.3.4"),
+		},
+		UpdateNew: []*endpoint.Endpoint{
+			endpoint.NewEndpointWithTTL("txt.bar.com", endpoint.RecordTypeTXT, 300, "third"),
+			endpoint.NewEndpointWithTTL("gone.bar.com", endpoint.RecordTypeA, 300, "5.6.7.8"),
+		},
+	})
+	if err != nil {
+		t.Fatalf("should not fail, %s", err)
+	}
+
+	td.Cmp(t, client.Actions, td.Bag(
+		// only the record holding the previous state is changed, R040 is left alone
+		MockAction{
+			Name:   "Update",
+			ZoneId: "Z001",
+			RecordData: gobizfly.Record{
+				ID:     "R041",
+				Name:   "txt.bar.com",
+				ZoneID: "Z001",
+				Type:   endpoint.RecordTypeTXT,
+				TTL:    300,
+				Data:   makeRecordData([]string{"third"}),
+			},
+		},
+		// the record disappeared since external-dns read it, so it is added again
+		MockAction{
+			Name:   "Create",
+			ZoneId: "Z001",
+			RecordData: gobizfly.Record{
+				Name:   "gone.bar.com",
+				ZoneID: "Z001",
+				Type:   endpoint.RecordTypeA,
+				TTL:    300,
+				Data:   makeRecordData([]string{"5.6.7.8"}),
+			},
+		},
+	))
+}
+
+func TestBizflycloudApplyChangesUpdateMerged(t *testing.T) {
+	client := NewMockBizflyCloudClientWithRecords([]gobizfly.Record{
+		{
+			ID:     "R040",
+			ZoneID: "Z001",
+			Name:   "txt",
+			Type:   endpoint.RecordTypeTXT,
+			TTL:    300,
+			Data:   makeRecordData([]string{"first"}),
+		},
+		{
+			ID:     "R041",
+			ZoneID: "Z001",
+			Name:   "txt",
+			Type:   endpoint.RecordTypeTXT,
+			TTL:    300,
+			Data:   makeRecordData([]string{"second"}),
+		},
+	})
+	provider := &BizflyCloudProvider{
+		Client: client,
+	}
+
+	err := provider.ApplyChanges(context.Background(), &plan.Changes{
+		UpdateOld: []*endpoint.Endpoint{
+			endpoint.NewEndpointWithTTL("txt.bar.com", endpoint.RecordTypeTXT, 300, "first", "second"),
+			endpoint.NewEndpointWithTTL("gone.bar.com", endpoint.RecordTypeA, 300, "1.2.3.4"),
+		},
+		UpdateNew: []*endpoint.Endpoint{
+			endpoint.NewEndpointWithTTL("txt.bar.com", endpoint.RecordTypeTXT, 300, "first", "third"),
+			endpoint.NewEndpointWithTTL("gone.bar.com", endpoint.RecordTypeA, 300, "5.6.7.8"),
+		},
+	})
+	if err != nil {
+		t.Fatalf("should not fail, %s", err)
+	}
+
+	td.Cmp(t, client.Actions, td.Bag(
+		// the new target takes the place of the removed one, the other record is left alone
+		MockAction{
+			Name:   "Update",
+			ZoneId: "Z001",
+			RecordData: gobizfly.Record{
+				ID:     "R041",
+				Name:   "txt.bar.com",
+				ZoneID: "Z001",
+				Type:   endpoint.RecordTypeTXT,
+				TTL:    300,
+				Data:   makeRecordData([]string{"third"}),
+			},
+		},
+		// the record disappeared since external-dns read it, so it is added again
+		MockAction{
+			Name:   "Create",
+			ZoneId: "Z001",
+			RecordData: gobizfly.Record{
+				Name:   "gone.bar.com",
+				ZoneID: "Z001",
+				Type:   endpoint.RecordTypeA,
+				TTL:    300,
+				Data:   makeRecordData([]string{"5.6.7.8"}),
+			},
+		},
+	))
+}
+
+func TestBizflycloudPlanChanges(t *testing.T) {
+	client := NewMockBizflyCloudClientWithRecords(ExampleRecrods)
+	provider := &BizflyCloudProvider{
+		Client: client,
+	}
+
+	changePlan, err := provider.PlanChanges(context.Background(), &plan.Changes{
+		Create: []*endpoint.Endpoint{
+			endpoint.NewEndpointWithTTL("new.bar.com", endpoint.RecordTypeA, 60, "1.2.3.4"),
+			endpoint.NewEndpoint("new.unrelated.to", endpoint.RecordTypeA, "1.2.3.4"),
+			endpoint.NewEndpoint("bar.com", endpoint.RecordTypeMX, "mx.bar.com"),
+		},
+		UpdateOld: []*endpoint.Endpoint{
+			endpoint.NewEndpointWithTTL("foobar.bar.com", endpoint.RecordTypeA, 120, "1.2.3.4", "3.4.5.6"),
+		},
+		UpdateNew: []*endpoint.Endpoint{
+			endpoint.NewEndpointWithTTL("foobar.bar.com", endpoint.RecordTypeA, 120, "1.2.3.4", "5.6.7.8"),
+		},
+		Delete: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("foo.bar.com", endpoint.RecordTypeA, "3.4.5.6"),
+			endpoint.NewEndpoint("missing.bar.com", endpoint.RecordTypeA, "1.2.3.4"),
+		},
+	})
+	if err != nil {
+		t.Fatalf("should not fail, %s", err)
+	}
+
+	// nothing is executed
+	td.Cmp(t, client.Actions, td.Len(0))
+
+	td.Cmp(t, changePlan.Zones, []*pkgprovider.ZonePlan{{
+		ID:   "Z001",
+		Name: "bar.com",
+		Operations: []*pkgprovider.PlannedOperation{
+			{Action: bizflyCloudDelete, ChangeAction: bizflyCloudDelete, Record: "foo.bar.com", Type: endpoint.RecordTypeA, RecordID: "R002"},
+			{
+				Action:       bizflyCloudUpdate,
+				ChangeAction: bizflyCloudUpdate,
+				Record:       "foobar.bar.com",
+				Type:         endpoint.RecordTypeA,
+				RecordID:     "R001",
+				Payload: getUpdateDNSRecordParam(bizflyCloudChange{NormalRecord: NormalRecord{
+					Name: "foobar.bar.com", Type: endpoint.RecordTypeA, TTL: 120, Data: []string{"1.2.3.4", "5.6.7.8"},
+				}}),
+			},
+			{
+				Action:       bizflyCloudCreate,
+				ChangeAction: bizflyCloudCreate,
+				Record:       "new.bar.com",
+				Type:         endpoint.RecordTypeA,
+				Payload: getCreateDNSRecordParam(bizflyCloudChange{NormalRecord: NormalRecord{
+					Name: "new.bar.com", Type: endpoint.RecordTypeA, TTL: 60, Data: []string{"1.2.3.4"},
+				}}),
+			},
+		},
+	}})
+
+	td.Cmp(t, changePlan.Skipped, td.Bag(
+		td.Struct(&pkgprovider.RecordError{Record: "bar.com", Type: endpoint.RecordTypeMX, Action: bizflyCloudCreate},
+			td.StructFields{"Reason": td.Contains("invalid MX target"), "Err": td.NotNil()}),
+		&pkgprovider.RecordError{
+			Record: "new.unrelated.to",
+			Type:   endpoint.RecordTypeA,
+			Action: bizflyCloudCreate,
+			Reason: errNoMatchingZone.Error(),
+			Err:    errNoMatchingZone,
+		},
+		&pkgprovider.RecordError{
+			Zone:   "Z001",
+			Record: "missing.bar.com",
+			Type:   endpoint.RecordTypeA,
+			Action: bizflyCloudDelete,
+			Reason: errRecordNotFound.Error(),
+			Err:    errRecordNotFound,
+		},
+	))
+
+	// DryRun logs the same operations without executing them
+	provider.DryRun = true
+	if err := provider.ApplyChanges(context.Background(), &plan.Changes{
+		Create: []*endpoint.Endpoint{endpoint.NewEndpoint("new.bar.com", endpoint.RecordTypeA, "1.2.3.4")},
+	}); err != nil {
+		t.Errorf("should not fail, %s", err)
+	}
+	td.Cmp(t, client.Actions, td.Len(0))
+}
+
+func TestBizflycloudMergeRecords(t *testing.T) {
+	client := NewMockBizflyCloudClientWithRecords([]gobizfly.Record{
+		{
+			ID:     "R050",
+			ZoneID: "Z001",
+			Name:   "multi",
+			Type:   endpoint.RecordTypeA,
+			TTL:    300,
+			Data:   makeRecordData([]string{"1.1.1.1", "2.2.2.2"}),
+		},
+		{
+			ID:     "R051",
+			ZoneID: "Z001",
+			Name:   "multi",
+			Type:   endpoint.RecordTypeA,
+			TTL:    300,
+			Data:   makeRecordData([]string{"2.2.2.2", "3.3.3.3"}),
+		},
+	})
+	provider := &BizflyCloudProvider{
+		Client:       client,
+		domainFilter: endpoint.NewDomainFilter([]string{"bar.com"}),
+	}
+
+	records, err := provider.Records(context.Background())
+	if err != nil {
+		t.Fatalf("should not fail, %s", err)
+	}
+	td.Cmp(t, records, []*endpoint.Endpoint{
+		endpoint.NewEndpointWithTTL("multi.bar.com", endpoint.RecordTypeA, 300, "1.1.1.1", "2.2.2.2", "3.3.3.3"),
+	})
+
+	err = provider.ApplyChanges(context.Background(), &plan.Changes{
+		UpdateOld: records,
+		UpdateNew: []*endpoint.Endpoint{
+			endpoint.NewEndpointWithTTL("multi.bar.com", endpoint.RecordTypeA, 300, "1.1.1.1", "4.4.4.4", "5.5.5.5"),
+		},
+	})
+	if err != nil {
+		t.Fatalf("should not fail, %s", err)
+	}
+	td.Cmp(t, client.Actions, []MockAction{
+		{
+			Name:   "Update",
+			ZoneId: "Z001",
+			RecordData: gobizfly.Record{
+				ID:     "R050",
+				Name:   "multi.bar.com",
+				ZoneID: "Z001",
+				Type:   endpoint.RecordTypeA,
+				TTL:    300,
+				Data:   makeRecordData([]string{"1.1.1.1", "4.4.4.4"}),
+			},
+		},
+		{
+			Name:   "Update",
+			ZoneId: "Z001",
+			RecordData: gobizfly.Record{
+				ID:     "R051",
+				Name:   "multi.bar.com",
+				ZoneID: "Z001",
+				Type:   endpoint.RecordTypeA,
+				TTL:    300,
+				Data:   makeRecordData([]string{"5.5.5.5"}),
+			},
+		},
+	})
+
+	client.Actions = nil
+	err = provider.ApplyChanges(context.Background(), &plan.Changes{
+		Delete: []*endpoint.Endpoint{
+			endpoint.NewEndpointWithTTL("multi.bar.com", endpoint.RecordTypeA, 300, "1.1.1.1", "4.4.4.4", "5.5.5.5"),
+		},
+	})
+	if err != nil {
+		t.Fatalf("should not fail, %s", err)
+	}
+	td.Cmp(t, client.Actions, []MockAction{
+		{Name: "Delete", ZoneId: "Z001", RecordData: gobizfly.Record{ID: "R050"}},
+		{Name: "Delete", ZoneId: "Z001", RecordData: gobizfly.Record{ID: "R051"}},
+	})
+}
+
+func TestBizflycloudApplyChangesConcurrently(t *testing.T) {
+	client := NewMockBizflyCloudClientWithRecords(ExampleRecrods)
+	provider := &BizflyCloudProvider{
+		Client:           client,
+		applyConcurrency: 4,
+	}
+
+	changes := &plan.Changes{
+		// foo.bar.com is replaced, its delete has to go first
+		Create: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("foo.bar.com", endpoint.RecordTypeA, "9.9.9.9"),
+		},
+		Delete: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("foo.bar.com", endpoint.RecordTypeA, "3.4.5.6"),
+			endpoint.NewEndpoint("missing-b.foo.com", endpoint.RecordTypeA, "1.1.1.1"),
+			endpoint.NewEndpoint("missing-a.bar.com", endpoint.RecordTypeA, "1.1.1.1"),
+		},
+	}
+	for i := 0; i < 20; i++ {
+		changes.Create = append(changes.Create, endpoint.NewEndpoint(fmt.Sprintf("host%02d.foo.com", i), endpoint.RecordTypeA, "1.2.3.4"))
+	}
+
+	err := provider.ApplyChanges(context.Background(), changes)
+
+	var applyErr *pkgprovider.ApplyChangesError
+	if !errors.As(err, &applyErr) {
+		t.Fatalf("expected an ApplyChangesError, got %v", err)
+	}
+	// failures are reported ordered by zone and name
+	td.Cmp(t, applyErr.Errors, []*pkgprovider.RecordError{
+		{Zone: "Z001", Record: "missing-a.bar.com", Type: "A", Action: bizflyCloudDelete, Reason: errRecordNotFound.Error(), Err: errRecordNotFound},
+		{Zone: "Z002", Record: "missing-b.foo.com", Type: "A", Action: bizflyCloudDelete, Reason: errRecordNotFound.Error(), Err: errRecordNotFound},
+	})
+
+	td.Cmp(t, client.Actions, td.Len(22))
+	var fooActions []string
+	for _, action := range client.Actions {
+		if action.RecordData.ID == "R002" || action.RecordData.Name == "foo.bar.com" {
+			fooActions = append(fooActions, action.Name)
+		}
+	}
+	td.Cmp(t, fooActions, []string{"Delete", "Create"})
+}
+
+// countingBizflyCloudClient counts the reads reaching the wrapped client.
+type countingBizflyCloudClient struct {
+	bizflyCloudDNS
+	listZones int
+	getZone   map[string]int
+}
+
+func (c *countingBizflyCloudClient) ListZones(ctx context.Context, opts *gobizfly.ListOptions) (*gobizfly.ListZoneResp, error) {
+	c.listZones++
+	return c.bizflyCloudDNS.ListZones(ctx, opts)
+}
+
+func (c *countingBizflyCloudClient) GetZone(ctx context.Context, zoneID string) (*gobizfly.ExtendedZone, error) {
+	c.getZone[zoneID]++
+	return c.bizflyCloudDNS.GetZone(ctx, zoneID)
+}
+
+func TestBizflycloudCachedRecords(t *testing.T) {
+	counting := &countingBizflyCloudClient{
+		bizflyCloudDNS: NewMockBizflyCloudClientWithRecords(ExampleRecrods),
+		getZone:        map[string]int{},
+	}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := newCachingDNS(counting, time.Minute)
+	cache.now = func() time.Time { return now }
+	provider := &BizflyCloudProvider{Client: cache}
+
+	for i := 0; i < 2; i++ {
+		records, err := provider.Records(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, 3, len(records))
+	}
+	assert.Equal(t, 1, counting.listZones)
+	assert.Equal(t, map[string]int{"Z001": 1, "Z002": 1}, counting.getZone)
+
+	// ApplyChanges reuses the listing and only the written zone is fetched again afterwards
+	err := provider.ApplyChanges(context.Background(), &plan.Changes{
+		Create: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("new.bar.com", endpoint.RecordTypeA, "1.2.3.4"),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := provider.Records(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 4, len(records))
+	assert.Equal(t, 1, counting.listZones)
+	assert.Equal(t, map[string]int{"Z001": 2, "Z002": 1}, counting.getZone)
+
+	// a delete only knows the record ID, the zone holding it is invalidated
+	err = provider.ApplyChanges(context.Background(), &plan.Changes{
+		Delete: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("bar.foo.com", endpoint.RecordTypeA, "2.3.4.5"),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := provider.Records(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, map[string]int{"Z001": 2, "Z002": 2}, counting.getZone)
+
+	// expired entries are fetched again
+	now = now.Add(time.Minute)
+	if _, err := provider.Records(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 2, counting.listZones)
+	assert.Equal(t, map[string]int{"Z001": 3, "Z002": 3}, counting.getZone)
+}
+
+// flakyBizflyCloudClient fails calls with the queued errors before passing them on.
+type flakyBizflyCloudClient struct {
+	bizflyCloudDNS
+	failures map[string][]error
+	calls    map[string]int
+}
+
+func (c *flakyBizflyCloudClient) fail(method string) error {
+	c.calls[method]++
+	if errs := c.failures[method]; len(errs) > 0 {
+		c.failures[method] = errs[1:]
+		return errs[0]
+	}
+	return nil
+}
+
+func (c *flakyBizflyCloudClient) ListZones(ctx context.Context, opts *gobizfly.ListOptions) (*gobizfly.ListZoneResp, error) {
+	if err := c.fail("ListZones"); err != nil {
+		return nil, err
+	}
+	return c.bizflyCloudDNS.ListZones(ctx, opts)
+}
+
+func (c *flakyBizflyCloudClient) CreateRecord(ctx context.Context, zoneID string, crpl interface{}) (*gobizfly.Record, error) {
+	if err := c.fail("CreateRecord"); err != nil {
+		return nil, err
+	}
+	return c.bizflyCloudDNS.CreateRecord(ctx, zoneID, crpl)
+}
+
+func (c *flakyBizflyCloudClient) DeleteRecord(ctx context.Context, recordID string) error {
+	if err := c.fail("DeleteRecord"); err != nil {
+		return err
+	}
+	return c.bizflyCloudDNS.DeleteRecord(ctx, recordID)
+}
+
+func TestBizflycloudRetries(t *testing.T) {
+	unavailable := &url.Error{Op: "Get", URL: "https://dns", Err: &apiError{StatusCode: http.StatusServiceUnavailable}}
+	badGateway := &apiError{StatusCode: http.StatusBadGateway}
+	rateLimited := &apiError{StatusCode: http.StatusTooManyRequests, RetryAfter: 3 * time.Second}
+	notFound := fmt.Errorf("record not found: %w", gobizfly.ErrNotFound)
+
+	for _, tc := range []struct {
+		name     string
+		failures map[string][]error
+		changes  *plan.Changes
+		err      string
+		calls    map[string]int
+		sleeps   []time.Duration
+	}{
+		{
+			name:     "listing backs off exponentially",
+			failures: map[string][]error{"ListZones": {unavailable, unavailable}},
+			calls:    map[string]int{"ListZones": 3},
+			sleeps:   []time.Duration{50 * time.Millisecond, 100 * time.Millisecond},
+		},
+		{
+			name:     "attempts are limited",
+			failures: map[string][]error{"ListZones": {unavailable, unavailable, unavailable, unavailable, unavailable}},
+			err:      unavailable.Error(),
+			calls:    map[string]int{"ListZones": 4},
+			sleeps:   []time.Duration{50 * time.Millisecond, 100 * time.Millisecond, 150 * time.Millisecond},
+		},
+		{
+			name:     "other errors are not retried",
+			failures: map[string][]error{"ListZones": {gobizfly.ErrPermissionDenied}},
+			err:      gobizfly.ErrPermissionDenied.Error(),
+			calls:    map[string]int{"ListZones": 1},
+		},
+		{
+			name:     "rate limited creates wait for Retry-After",
+			failures: map[string][]error{"CreateRecord": {rateLimited}},
+			changes: &plan.Changes{Create: []*endpoint.Endpoint{
+				endpoint.NewEndpoint("new.bar.com", endpoint.RecordTypeA, "1.2.3.4"),
+			}},
+			calls:  map[string]int{"ListZones": 1, "CreateRecord": 2},
+			sleeps: []time.Duration{3 * time.Second},
+		},
+		{
+			name:     "failed creates are not sent twice",
+			failures: map[string][]error{"CreateRecord": {badGateway}},
+			changes: &plan.Changes{Create: []*endpoint.Endpoint{
+				endpoint.NewEndpoint("new.bar.com", endpoint.RecordTypeA, "1.2.3.4"),
+			}},
+			err:   badGateway.Error(),
+			calls: map[string]int{"ListZones": 1, "CreateRecord": 1},
+		},
+		{
+			name:     "records already deleted by a lost attempt",
+			failures: map[string][]error{"DeleteRecord": {badGateway, notFound}},
+			changes: &plan.Changes{Delete: []*endpoint.Endpoint{
+				endpoint.NewEndpoint("foo.bar.com", endpoint.RecordTypeA, "3.4.5.6"),
+			}},
+			calls:  map[string]int{"ListZones": 1, "DeleteRecord": 2},
+			sleeps: []time.Duration{50 * time.Millisecond},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			flaky := &flakyBizflyCloudClient{
+				bizflyCloudDNS: NewMockBizflyCloudClientWithRecords(ExampleRecrods),
+				failures:       tc.failures,
+				calls:          map[string]int{},
+			}
+			retrying := newRetryingDNS(flaky, 4, 100*time.Millisecond, 300*time.Millisecond)
+			var sleeps []time.Duration
+			retrying.sleep = func(ctx context.Context, d time.Duration) error {
+				sleeps = append(sleeps, d)
+				return nil
+			}
+			retrying.jitter = func(d time.Duration) time.Duration { return 0 }
+			provider := &BizflyCloudProvider{Client: retrying}
+
+			var err error
+			if tc.changes != nil {
+				err = provider.ApplyChanges(context.Background(), tc.changes)
+			} else {
+				_, err = provider.Records(context.Background())
+			}
+			if tc.err == "" {
+				assert.NoError(t, err)
+			} else if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tc.err)
+			}
+			assert.Equal(t, tc.calls, flaky.calls)
+			assert.Equal(t, tc.sleeps, sleeps)
+		})
+	}
+}
+
+func TestAPIErrorTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/dns/zone/Z001":
+			w.Header().Set("Retry-After", "7")
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprint(w, "slow down")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: newAPIErrorTransport(http.DefaultTransport)}
+	resp, err := client.Get(server.URL + "/dns/zone/Z002")
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	}
+
+	_, err = client.Get(server.URL + "/dns/zone/Z001")
+	var apiErr *apiError
+	if assert.ErrorAs(t, err, &apiErr) {
+		assert.Equal(t, &apiError{StatusCode: http.StatusTooManyRequests, RetryAfter: 7 * time.Second, Body: "slow down"}, apiErr)
+		assert.True(t, retryable(err, false))
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, 120*time.Second, parseRetryAfter("120", now))
+	assert.Equal(t, 90*time.Second, parseRetryAfter("Mon, 01 Jan 2024 00:01:30 GMT", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("Sun, 31 Dec 2023 23:00:00 GMT", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("soon", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("", now))
+}
+
+func TestBizflycloudRateLimit(t *testing.T) {
+	hook := logtest.NewGlobal()
+	defer hook.Reset()
+	counting := &countingBizflyCloudClient{
+		bizflyCloudDNS: NewMockBizflyCloudClientWithRecords(ExampleRecrods),
+		getZone:        map[string]int{},
+	}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	limited := newRateLimitedDNS(counting, 2, 2)
+	limited.now = func() time.Time { return now }
+	var sleeps []time.Duration
+	limited.sleep = func(ctx context.Context, d time.Duration) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		sleeps = append(sleeps, d)
+		now = now.Add(d)
+		return nil
+	}
+	provider := &BizflyCloudProvider{Client: limited}
+
+	// ListZones and two GetZone calls fit into the burst
+	_, err := provider.Records(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []time.Duration{500 * time.Millisecond}, sleeps)
+
+	_, err = provider.Records(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []time.Duration{500 * time.Millisecond, 500 * time.Millisecond, 500 * time.Millisecond, 500 * time.Millisecond}, sleeps)
+
+	// a canceled call gives its token back and does not reach the API
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = limited.ListZones(ctx, &gobizfly.ListOptions{})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 2, counting.listZones)
+
+	// the waits are reported by the first call once the report interval is over
+	now = now.Add(rateLimitReportInterval)
+	_, err = limited.ListZones(context.Background(), &gobizfly.ListOptions{})
+	assert.NoError(t, err)
+	td.Cmp(t, hook.AllEntries(), td.Contains(td.Struct(&log.Entry{
+		Level:   log.InfoLevel,
+		Message: "Rate limiter delayed 4 of 7 Bizfly API calls in the last 1m2s, waiting 2s in total and 500ms at most",
+	}, nil)))
+}
+
+func TestBizflycloudMetrics(t *testing.T) {
+	metrics.ChangesTotal.Reset()
+	client := NewMockBizflyCloudClientWithRecords(ExampleRecrods)
+	provider := &BizflyCloudProvider{Client: newInstrumentedDNS(client)}
+
+	if _, err := provider.Records(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 2.0, testutil.ToFloat64(metrics.Records.WithLabelValues("bar.com", endpoint.RecordTypeA)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.Records.WithLabelValues("foo.com", endpoint.RecordTypeA)))
+
+	listZones := testutil.ToFloat64(metrics.APICallsTotal.WithLabelValues("ListZones"))
+	_ = provider.ApplyChanges(context.Background(), &plan.Changes{
+		Create: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("new.bar.com", endpoint.RecordTypeA, "1.2.3.4"),
+			endpoint.NewEndpoint("mail.bar.com", endpoint.RecordTypeMX, "mail.bar.com"),
+		},
+		Delete: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("missing.bar.com", endpoint.RecordTypeA, "1.2.3.4"),
+		},
+	})
+	assert.Equal(t, listZones+1, testutil.ToFloat64(metrics.APICallsTotal.WithLabelValues("ListZones")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.ChangesTotal.WithLabelValues(bizflyCloudCreate, metrics.ResultApplied)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.ChangesTotal.WithLabelValues(bizflyCloudCreate, metrics.ResultFailed)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.ChangesTotal.WithLabelValues(bizflyCloudDelete, metrics.ResultFailed)))
+}
+
+func TestBizflycloudReady(t *testing.T) {
+	flaky := &flakyBizflyCloudClient{
+		bizflyCloudDNS: NewMockBizflyCloudClientWithRecords(ExampleRecrods),
+		failures:       map[string][]error{},
+		calls:          map[string]int{},
+	}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	readiness := newReadinessCheck(time.Minute)
+	readiness.now = func() time.Time { return now }
+	provider := &BizflyCloudProvider{Client: readiness.watch(flaky), readiness: readiness}
+
+	// the first probe lists zones, the next ones use its result
+	assert.NoError(t, provider.Ready(context.Background()))
+	assert.NoError(t, provider.Ready(context.Background()))
+	assert.Equal(t, 1, flaky.calls["ListZones"])
+
+	// a failed listing makes the provider unready until the next probe after the staleness window
+	now = now.Add(10 * time.Second)
+	flaky.failures["ListZones"] = []error{gobizfly.ErrPermissionDenied}
+	_, err := provider.Records(context.Background())
+	assert.ErrorIs(t, err, gobizfly.ErrPermissionDenied)
+	assert.ErrorIs(t, provider.Ready(context.Background()), gobizfly.ErrPermissionDenied)
+	assert.Equal(t, 2, flaky.calls["ListZones"])
+
+	now = now.Add(time.Minute)
+	assert.NoError(t, provider.Ready(context.Background()))
+	assert.Equal(t, 3, flaky.calls["ListZones"])
+}
+
+func TestBizflycloudReadyIgnoresCache(t *testing.T) {
+	flaky := &flakyBizflyCloudClient{
+		bizflyCloudDNS: NewMockBizflyCloudClientWithRecords(ExampleRecrods),
+		failures:       map[string][]error{},
+		calls:          map[string]int{},
+	}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	readiness := newReadinessCheck(time.Minute)
+	readiness.now = func() time.Time { return now }
+	cache := newCachingDNS(readiness.watch(flaky), time.Hour)
+	cache.now = func() time.Time { return now }
+	provider := &BizflyCloudProvider{Client: cache, readiness: readiness}
+
+	_, err := provider.Records(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, flaky.calls["ListZones"])
+
+	// the zones are still cached when the API starts failing, the probe must reach the API anyway
+	now = now.Add(2 * time.Minute)
+	flaky.failures["ListZones"] = []error{gobizfly.ErrPermissionDenied}
+	_, err = provider.Records(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, flaky.calls["ListZones"])
+	assert.ErrorIs(t, provider.Ready(context.Background()), gobizfly.ErrPermissionDenied)
+	assert.Equal(t, 2, flaky.calls["ListZones"])
+}
+
+func TestBizflycloudTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tracerProvider := tracing.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer func() { _ = tracerProvider.Shutdown(context.Background()) }()
+
+	provider := &BizflyCloudProvider{Client: NewMockBizflyCloudClientWithRecords(ExampleRecrods)}
+	ctx, root := tracing.Start(context.Background(), "test")
+	err := provider.ApplyChanges(ctx, &plan.Changes{
+		Create: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("new.bar.com", endpoint.RecordTypeA, "1.2.3.4"),
+		},
+		Delete: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("missing.bar.com", endpoint.RecordTypeA, "1.2.3.4"),
+		},
+	})
+	root.End()
+	assert.Error(t, err)
+
+	spans := map[string]tracetest.SpanStub{}
+	for _, span := range exporter.GetSpans() {
+		spans[span.Name] = span
+		if span.Name != "test" {
+			assert.Equal(t, root.SpanContext().TraceID(), span.SpanContext.TraceID(), span.Name)
+		}
+	}
+	assert.Contains(t, spans, "bizflycloud.listZones")
+	assert.Contains(t, spans["bizflycloud.GetZone"].Attributes, attribute.String("zone.id", "Z001"))
+	assert.Contains(t, spans["bizflycloud.GetZone"].Attributes, attribute.String("zone.name", "bar.com"))
+	assert.Contains(t, spans["bizflycloud.createRecord"].Attributes, attribute.String("record.name", "new.bar.com"))
+	assert.Contains(t, spans["bizflycloud.createRecord"].Attributes, attribute.String("record.type", endpoint.RecordTypeA))
+	// the delete failed before reaching the API
+	assert.NotContains(t, spans, "bizflycloud.deleteRecord")
+}
+
+func TestBizflycloudAudit(t *testing.T) {
+	var buf bytes.Buffer
+	client := &flakyBizflyCloudClient{
+		bizflyCloudDNS: NewMockBizflyCloudClientWithRecords(ExampleRecrods),
+		failures:       map[string][]error{"CreateRecord": {errors.New("quota exceeded")}},
+		calls:          map[string]int{},
+	}
+	provider := &BizflyCloudProvider{Client: client, audit: audit.NewSink(&buf)}
+
+	ctx := requestid.NewContext(context.Background(), "req-1")
+	_ = provider.ApplyChanges(ctx, &plan.Changes{
+		Create: []*endpoint.Endpoint{
+			endpoint.NewEndpointWithTTL("new.bar.com", endpoint.RecordTypeA, 60, "1.2.3.4"),
+		},
+		UpdateOld: []*endpoint.Endpoint{
+			endpoint.NewEndpointWithTTL("foobar.bar.com", endpoint.RecordTypeA, 120, "1.2.3.4", "3.4.5.6"),
+		},
+		UpdateNew: []*endpoint.Endpoint{
+			endpoint.NewEndpointWithTTL("foobar.bar.com", endpoint.RecordTypeA, 120, "1.2.3.4", "5.6.7.8"),
+		},
+		Delete: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("foo.bar.com", endpoint.RecordTypeA, "3.4.5.6"),
+		},
+	})
+
+	events := []map[string]interface{}{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		event := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatalf("expected a JSON line, got '%s': %v", line, err)
+		}
+		events = append(events, event)
+	}
+	td.Cmp(t, events, td.Bag(
+		td.SuperMapOf(map[string]interface{}{
+			"correlationId": "req-1",
+			"zoneId":        "Z001",
+			"zone":          "bar.com",
+			"record":        "foo.bar.com",
+			"type":          "A",
+			"action":        bizflyCloudDelete,
+			"recordId":      "R002",
+			"old":           map[string]interface{}{"ttl": 120.0, "data": []interface{}{"3.4.5.6"}},
+			"result":        audit.ResultApplied,
+		}, td.MapEntries{"timestamp": td.NotEmpty()}),
+		td.SuperMapOf(map[string]interface{}{
+			"correlationId": "req-1",
+			"record":        "foobar.bar.com",
+			"action":        bizflyCloudUpdate,
+			"recordId":      "R001",
+			"old":           map[string]interface{}{"ttl": 120.0, "data": []interface{}{"1.2.3.4", "3.4.5.6"}},
+			"new":           td.SuperMapOf(map[string]interface{}{"data": []interface{}{"1.2.3.4", "5.6.7.8"}}, nil),
+			"result":        audit.ResultApplied,
+		}, nil),
+		td.SuperMapOf(map[string]interface{}{
+			"correlationId": "req-1",
+			"record":        "new.bar.com",
+			"action":        bizflyCloudCreate,
+			"new":           td.SuperMapOf(map[string]interface{}{"data": []interface{}{"1.2.3.4"}}, nil),
+			"result":        audit.ResultFailed,
+			"error":         "quota exceeded",
+		}, nil),
+	))
+}
+
+func TestBizflycloudRequestIDLogging(t *testing.T) {
+	hook := logtest.NewGlobal()
+	defer hook.Reset()
+	log.SetLevel(log.DebugLevel)
+	client := &flakyBizflyCloudClient{
+		bizflyCloudDNS: NewMockBizflyCloudClientWithRecords(ExampleRecrods),
+		failures:       map[string][]error{"CreateRecord": {&apiError{StatusCode: http.StatusTooManyRequests}}},
+		calls:          map[string]int{},
+	}
+	provider := &BizflyCloudProvider{Client: newRetryingDNS(client, 2, time.Millisecond, time.Millisecond)}
+
+	ctx := requestid.NewContext(context.Background(), "req-1")
+	_ = provider.ApplyChanges(ctx, &plan.Changes{
+		Create: []*endpoint.Endpoint{endpoint.NewEndpoint("new.bar.com", endpoint.RecordTypeA, "1.2.3.4")},
+		Delete: []*endpoint.Endpoint{endpoint.NewEndpoint("missing.bar.com", endpoint.RecordTypeA, "1.2.3.4")},
+	})
+	provider.AdjustEndpointsContext(ctx, []*endpoint.Endpoint{
+		endpoint.NewEndpoint("tcp.bar.com", endpoint.RecordTypeA, "1.1.1.1").
+			WithProviderSpecific(providerSpecificHealthCheckProtocol, "tcp"),
+	})
+
+	// the retry, the changes, the failed delete and the invalid endpoint are all logged with the request ID
+	td.Cmp(t, hook.AllEntries(), td.All(
+		td.Len(td.Gte(5)),
+		td.ArrayEach(td.Smuggle("Data", td.SuperMapOf(log.Fields{requestid.LogField: "req-1"}, nil))),
+	))
+}
+
+func TestBizflycloudApplyChangesUnmatched(t *testing.T) {
+	hook := logtest.NewGlobal()
+	defer hook.Reset()
+	client := NewMockBizflyCloudClientWithRecords(ExampleRecrods)
+	provider := &BizflyCloudProvider{Client: client}
+
+	// a change outside of all zones is skipped like PlanChanges reports it, with a warning
+	err := provider.ApplyChanges(context.Background(), &plan.Changes{
+		Create: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("new.bar.com", endpoint.RecordTypeA, "1.2.3.4"),
+			endpoint.NewEndpoint("new.unrelated.to", endpoint.RecordTypeA, "1.2.3.4"),
+		},
+	})
+	assert.NoError(t, err)
+	td.Cmp(t, client.Actions, td.Bag(td.Struct(MockAction{Name: "Create", ZoneId: "Z001"}, nil)))
+	td.Cmp(t, hook.AllEntries(), td.Contains(td.Struct(&log.Entry{
+		Level:   log.WarnLevel,
+		Message: "Skipping record new.unrelated.to: no hosted zone matches the record name",
+	}, td.StructFields{"Data": td.SuperMapOf(log.Fields{"record": "new.unrelated.to", "action": bizflyCloudCreate}, nil)})))
 }
 
 func TestBizflycloudGetRecordID(t *testing.T) {
@@ -0,0 +1,130 @@
+package bizflycloud
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/metrics"
+	"github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/requestid"
+	"github.com/bizflycloud/gobizfly"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// rateLimitReportInterval is how often the time spent waiting for the limiter is logged
+	rateLimitReportInterval = time.Minute
+)
+
+// rateLimitStats sums up the time calls spent waiting for the rate limiter since the last report.
+type rateLimitStats struct {
+	Calls     int
+	Delayed   int
+	TotalWait time.Duration
+	MaxWait   time.Duration
+}
+
+// rateLimitedDNS makes the calls of a bizflyCloudDNS take a token from a shared token bucket,
+// so that Records and ApplyChanges together stay below the rate limits of the API.
+type rateLimitedDNS struct {
+	bizflyCloudDNS
+	limiter *rate.Limiter
+	now     func() time.Time
+	sleep   sleepFunc
+
+	mu         sync.Mutex
+	interval   rateLimitStats
+	reportedAt time.Time
+}
+
+func newRateLimitedDNS(client bizflyCloudDNS, rps float64, burst int) *rateLimitedDNS {
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimitedDNS{
+		bizflyCloudDNS: client,
+		limiter:        rate.NewLimiter(rate.Limit(rps), burst),
+		now:            time.Now,
+		sleep:          sleepContext,
+	}
+}
+
+// wait blocks until the limiter allows another call.
+func (l *rateLimitedDNS) wait(ctx context.Context, operation string) error {
+	now := l.now()
+	reservation := l.limiter.ReserveN(now, 1)
+	delay := reservation.DelayFrom(now)
+	if delay > 0 {
+		requestid.Logger(ctx).Debugf("Bizfly API call %s is delayed %s by the rate limiter", operation, delay)
+		if err := l.sleep(ctx, delay); err != nil {
+			reservation.Cancel()
+			return err
+		}
+	}
+	l.record(ctx, delay)
+	return nil
+}
+
+func (l *rateLimitedDNS) record(ctx context.Context, delay time.Duration) {
+	metrics.APIRateLimitWait.Observe(delay.Seconds())
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.interval.Calls++
+	if delay > 0 {
+		l.interval.Delayed++
+		l.interval.TotalWait += delay
+	}
+	if delay > l.interval.MaxWait {
+		l.interval.MaxWait = delay
+	}
+
+	now := l.now()
+	if l.reportedAt.IsZero() {
+		l.reportedAt = now
+	}
+	if now.Sub(l.reportedAt) < rateLimitReportInterval {
+		return
+	}
+	if l.interval.Delayed > 0 {
+		requestid.Logger(ctx).Infof("Rate limiter delayed %d of %d Bizfly API calls in the last %s, waiting %s in total and %s at most",
+			l.interval.Delayed, l.interval.Calls, now.Sub(l.reportedAt).Round(time.Second), l.interval.TotalWait, l.interval.MaxWait)
+	}
+	l.interval = rateLimitStats{}
+	l.reportedAt = now
+}
+
+func (l *rateLimitedDNS) ListZones(ctx context.Context, opts *gobizfly.ListOptions) (*gobizfly.ListZoneResp, error) {
+	if err := l.wait(ctx, "ListZones"); err != nil {
+		return nil, err
+	}
+	return l.bizflyCloudDNS.ListZones(ctx, opts)
+}
+
+func (l *rateLimitedDNS) GetZone(ctx context.Context, zoneID string) (*gobizfly.ExtendedZone, error) {
+	if err := l.wait(ctx, "GetZone"); err != nil {
+		return nil, err
+	}
+	return l.bizflyCloudDNS.GetZone(ctx, zoneID)
+}
+
+func (l *rateLimitedDNS) CreateRecord(ctx context.Context, zoneID string, crpl interface{}) (*gobizfly.Record, error) {
+	if err := l.wait(ctx, "CreateRecord"); err != nil {
+		return nil, err
+	}
+	return l.bizflyCloudDNS.CreateRecord(ctx, zoneID, crpl)
+}
+
+func (l *rateLimitedDNS) UpdateRecord(ctx context.Context, recordID string, urpl interface{}) (*gobizfly.Record, error) {
+	if err := l.wait(ctx, "UpdateRecord"); err != nil {
+		return nil, err
+	}
+	return l.bizflyCloudDNS.UpdateRecord(ctx, recordID, urpl)
+}
+
+func (l *rateLimitedDNS) DeleteRecord(ctx context.Context, recordID string) error {
+	if err := l.wait(ctx, "DeleteRecord"); err != nil {
+		return err
+	}
+	return l.bizflyCloudDNS.DeleteRecord(ctx, recordID)
+}
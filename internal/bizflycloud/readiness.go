@@ -0,0 +1,94 @@
+package bizflycloud
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bizflycloud/gobizfly"
+)
+
+// readinessCheck remembers the outcome of the last zone listing, so that readiness probes
+// only call the API when no listing happened within the staleness window.
+type readinessCheck struct {
+	staleness time.Duration
+	now       func() time.Time
+	// client lists zones without the cache, so that probes reach the API. When nil the
+	// client of the provider is probed.
+	client bizflyCloudDNS
+
+	mu        sync.Mutex
+	checkedAt time.Time
+	err       error
+}
+
+func newReadinessCheck(staleness time.Duration) *readinessCheck {
+	return &readinessCheck{staleness: staleness, now: time.Now}
+}
+
+// watch makes client record the outcome of its zone listings in the check, and probes it directly.
+// It is placed below the cache, as cached listings do not tell whether the API can be reached.
+func (r *readinessCheck) watch(client bizflyCloudDNS) bizflyCloudDNS {
+	r.client = client
+	return &observingDNS{bizflyCloudDNS: client, readiness: r}
+}
+
+// observingDNS records the outcome of the zone listings of a bizflyCloudDNS in a readinessCheck.
+type observingDNS struct {
+	bizflyCloudDNS
+	readiness *readinessCheck
+}
+
+func (o *observingDNS) ListZones(ctx context.Context, opts *gobizfly.ListOptions) (*gobizfly.ListZoneResp, error) {
+	resp, err := o.bizflyCloudDNS.ListZones(ctx, opts)
+	o.readiness.observe(err)
+	return resp, err
+}
+
+// observe records the outcome of a zone listing.
+func (r *readinessCheck) observe(err error) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checkedAt = r.now()
+	r.err = err
+}
+
+// check returns the outcome of the last listing if it is recent enough, otherwise of a new probe.
+func (r *readinessCheck) check(probe func() error) error {
+	if r == nil {
+		return probe()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.checkedAt.IsZero() && r.now().Sub(r.checkedAt) < r.staleness {
+		return r.err
+	}
+	r.err = probe()
+	r.checkedAt = r.now()
+	return r.err
+}
+
+// Ready reports whether the provider holds a valid token and could list zones recently.
+func (p *BizflyCloudProvider) Ready(ctx context.Context) error {
+	if p.tokens != nil {
+		if _, err := p.tokens.validToken(ctx); err != nil {
+			return fmt.Errorf("no valid token: %w", err)
+		}
+	}
+	client := p.Client
+	if p.readiness != nil && p.readiness.client != nil {
+		client = p.readiness.client
+	}
+	err := p.readiness.check(func() error {
+		_, err := client.ListZones(ctx, &gobizfly.ListOptions{Page: 1, Limit: 1})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list zones: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package bizflycloud
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/requestid"
+	"github.com/bizflycloud/gobizfly"
+)
+
+// apiError is returned by apiErrorTransport for responses worth retrying. gobizfly turns error
+// responses into errors that only keep the body, so the status and Retry-After header are
+// captured before it gets to them.
+type apiError struct {
+	StatusCode int
+	RetryAfter time.Duration
+	Body       string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Body)
+}
+
+// apiErrorTransport turns 429 and 5xx responses, except to token requests, into an *apiError.
+type apiErrorTransport struct {
+	base http.RoundTripper
+	now  func() time.Time
+}
+
+func newAPIErrorTransport(base http.RoundTripper) *apiErrorTransport {
+	return &apiErrorTransport{base: base, now: time.Now}
+}
+
+func (t *apiErrorTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	// gobizfly reads the response of token requests before checking for an error
+	if err != nil || isTokenRequest(req) || (resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < http.StatusInternalServerError) {
+		return resp, err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return nil, &apiError{
+		StatusCode: resp.StatusCode,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), t.now()),
+		Body:       strings.TrimSpace(string(body)),
+	}
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an HTTP date.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
+
+// retryingDNS retries the calls of a bizflyCloudDNS that failed for a transient reason,
+// backing off exponentially with jitter and waiting at least as long as the API asks for.
+type retryingDNS struct {
+	bizflyCloudDNS
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	sleep          sleepFunc
+	// jitter returns a random duration in [0, d)
+	jitter func(d time.Duration) time.Duration
+}
+
+func newRetryingDNS(client bizflyCloudDNS, maxAttempts int, initialBackoff, maxBackoff time.Duration) *retryingDNS {
+	return &retryingDNS{
+		bizflyCloudDNS: client,
+		maxAttempts:    maxAttempts,
+		initialBackoff: initialBackoff,
+		maxBackoff:     maxBackoff,
+		sleep:          sleepContext,
+		jitter: func(d time.Duration) time.Duration {
+			return time.Duration(rand.Int63n(int64(d)))
+		},
+	}
+}
+
+// sleepFunc waits for d or until ctx is done. It is replaced in tests to observe the waits.
+type sleepFunc func(ctx context.Context, d time.Duration) error
+
+// sleepContext is the sleepFunc waiting on a timer.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// retryable reports whether a failed call may succeed when it is sent again.
+// Creates are only retried when the API certainly did not process them, as
+// sending them again could otherwise add a duplicate record.
+func retryable(err error, idempotent bool) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		switch {
+		case apiErr.StatusCode == http.StatusTooManyRequests:
+			return true
+		case apiErr.StatusCode == http.StatusNotImplemented:
+			return false
+		default:
+			return idempotent
+		}
+	}
+	// a connection that could not be established never carried the request
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return idempotent || opErr.Op == "dial"
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return idempotent
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return idempotent
+	}
+	return false
+}
+
+// backoff returns how long to wait before the given retry, counting from 1.
+func (r *retryingDNS) backoff(retry int, err error) time.Duration {
+	delay := r.initialBackoff
+	for i := 1; i < retry && delay < r.maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > r.maxBackoff {
+		delay = r.maxBackoff
+	}
+	// wait somewhere between half and the full delay so that concurrent callers spread out
+	if half := delay / 2; half > 0 {
+		delay = half + r.jitter(half)
+	}
+	var apiErr *apiError
+	if errors.As(err, &apiErr) && apiErr.RetryAfter > delay {
+		delay = apiErr.RetryAfter
+	}
+	return delay
+}
+
+// do calls fn until it succeeds, fails for good or runs out of attempts.
+func (r *retryingDNS) do(ctx context.Context, operation string, idempotent bool, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= r.maxAttempts || !retryable(err, idempotent) {
+			return err
+		}
+		delay := r.backoff(attempt, err)
+		requestid.Logger(ctx).Warnf("Bizfly API call %s failed, retrying in %s (attempt %d/%d): %v", operation, delay, attempt, r.maxAttempts, err)
+		if sleepErr := r.sleep(ctx, delay); sleepErr != nil {
+			return err
+		}
+	}
+}
+
+func (r *retryingDNS) ListZones(ctx context.Context, opts *gobizfly.ListOptions) (*gobizfly.ListZoneResp, error) {
+	var resp *gobizfly.ListZoneResp
+	err := r.do(ctx, "ListZones", true, func() (err error) {
+		resp, err = r.bizflyCloudDNS.ListZones(ctx, opts)
+		return err
+	})
+	return resp, err
+}
+
+func (r *retryingDNS) GetZone(ctx context.Context, zoneID string) (*gobizfly.ExtendedZone, error) {
+	var zone *gobizfly.ExtendedZone
+	err := r.do(ctx, "GetZone", true, func() (err error) {
+		zone, err = r.bizflyCloudDNS.GetZone(ctx, zoneID)
+		return err
+	})
+	return zone, err
+}
+
+func (r *retryingDNS) CreateRecord(ctx context.Context, zoneID string, crpl interface{}) (*gobizfly.Record, error) {
+	var record *gobizfly.Record
+	err := r.do(ctx, "CreateRecord", false, func() (err error) {
+		record, err = r.bizflyCloudDNS.CreateRecord(ctx, zoneID, crpl)
+		return err
+	})
+	return record, err
+}
+
+func (r *retryingDNS) UpdateRecord(ctx context.Context, recordID string, urpl interface{}) (*gobizfly.Record, error) {
+	var record *gobizfly.Record
+	err := r.do(ctx, "UpdateRecord", true, func() (err error) {
+		record, err = r.bizflyCloudDNS.UpdateRecord(ctx, recordID, urpl)
+		return err
+	})
+	return record, err
+}
+
+// DeleteRecord deletes a record. A record that is gone when a delete is retried was
+// removed by an earlier attempt whose response got lost.
+func (r *retryingDNS) DeleteRecord(ctx context.Context, recordID string) error {
+	retried := false
+	return r.do(ctx, "DeleteRecord", true, func() error {
+		err := r.bizflyCloudDNS.DeleteRecord(ctx, recordID)
+		if retried && errors.Is(err, gobizfly.ErrNotFound) {
+			return nil
+		}
+		retried = true
+		return err
+	})
+}
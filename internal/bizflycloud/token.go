@@ -0,0 +1,316 @@
+package bizflycloud
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/requestid"
+	"github.com/bizflycloud/gobizfly"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// authServiceName is the gobizfly catalog name of the identity service
+	authServiceName = "auth"
+	// tokenPath is where tokens are created in the identity service
+	tokenPath = "/token"
+	// authTokenHeader carries the Keystone token of a request
+	authTokenHeader = "X-Auth-Token"
+	// defaultTokenLifetime is assumed when the expiry of a token can not be parsed
+	defaultTokenLifetime = time.Hour
+	// tokenRefreshTimeout bounds a refresh, which is shared by all waiting requests
+	tokenRefreshTimeout = 30 * time.Second
+)
+
+// errUnauthorized is returned instead of a 401 response the token manager can not recover from. gobizfly
+// answers a 401 by creating a token with the credentials it was initialised with and sending the request
+// again, without end when the token service rejects them as well.
+var errUnauthorized = errors.New("unauthorized")
+
+// tokenExpiryLayouts are the formats expire_at is known to come in, timestamps without zone are UTC
+var tokenExpiryLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999",
+	"2006-01-02 15:04:05.999999",
+}
+
+// tokenManager keeps the Keystone token of a gobizfly client valid. It is installed as the
+// transport of the client, refreshes the token ahead of its expiry and retries a request once
+// with a new token when it is rejected with 401. Concurrent requests share a single refresh.
+// When the credentials are read from files, they are checked for changes and a changed
+// credential replaces the token, which stays in use until the new one has been created.
+type tokenManager struct {
+	base          http.RoundTripper
+	refreshBefore time.Duration
+	now           func() time.Time
+
+	mu        sync.RWMutex
+	client    *gobizfly.Client
+	request   gobizfly.TokenCreateRequest
+	token     string
+	expiresAt time.Time
+	// rotated is set when the credentials changed, the token is replaced by the next request
+	rotated bool
+
+	credentials credentialWatch
+
+	refreshes singleflight.Group
+}
+
+func newTokenManager(base http.RoundTripper, refreshBefore time.Duration) *tokenManager {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &tokenManager{
+		base:          base,
+		refreshBefore: refreshBefore,
+		now:           time.Now,
+	}
+}
+
+// authenticate creates the first token of the client, which also loads its service catalog.
+// Requests are passed through unchanged until then. Token.Init is used rather than Token.Create, which
+// panics when the identity service can not be reached and retries rejected credentials without end.
+func (m *tokenManager) authenticate(ctx context.Context, client *gobizfly.Client, request *gobizfly.TokenCreateRequest) error {
+	token, err := client.Token.Init(ctx, request)
+	if err != nil {
+		return err
+	}
+	client.SetKeystoneToken(token)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.client = client
+	m.request = *request
+	m.setToken(ctx, token)
+	return nil
+}
+
+// setToken stores a new token, the caller must hold mu.
+func (m *tokenManager) setToken(ctx context.Context, token *gobizfly.Token) {
+	m.token = token.KeystoneToken
+	m.expiresAt = m.parseExpiry(ctx, token.ExpiresAt)
+	requestid.Logger(ctx).Debugf("Using Keystone token expiring at %s", m.expiresAt.Format(time.RFC3339))
+}
+
+func (m *tokenManager) parseExpiry(ctx context.Context, expiresAt string) time.Time {
+	for _, layout := range tokenExpiryLayouts {
+		if t, err := time.Parse(layout, expiresAt); err == nil {
+			return t
+		}
+	}
+	requestid.Logger(ctx).Warnf("Could not parse token expiry %q, refreshing it within %s", expiresAt, defaultTokenLifetime)
+	return m.now().Add(defaultTokenLifetime)
+}
+
+// RoundTrip sends a request with a valid token and retries it once after a 401. A 401 is never
+// returned to gobizfly, see errUnauthorized.
+func (m *tokenManager) RoundTrip(req *http.Request) (*http.Response, error) {
+	m.mu.RLock()
+	authenticated := m.client != nil
+	m.mu.RUnlock()
+	if !authenticated || isTokenRequest(req) {
+		return m.base.RoundTrip(req)
+	}
+	m.checkCredentials(req.Context())
+
+	token, err := m.validToken(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	resp, err := m.send(req, token)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	// the body of the first attempt has been consumed, requests that can not replay it are not retried
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return nil, fmt.Errorf("bizfly API rejected the token of a request that can not be sent again: %w", errUnauthorized)
+	}
+
+	requestid.Logger(req.Context()).Debugf("Request to %s was rejected with 401, re-authenticating", req.URL.Path)
+	token, err = m.refresh(req.Context(), token)
+	if err != nil {
+		return nil, err
+	}
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	resp, err = m.send(retry, token)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return nil, fmt.Errorf("bizfly API rejected the refreshed token: %w", errUnauthorized)
+}
+
+func (m *tokenManager) send(req *http.Request, token string) (*http.Response, error) {
+	// a RoundTripper must not modify the request it was given
+	req = req.Clone(req.Context())
+	req.Header.Set(authTokenHeader, token)
+	return m.base.RoundTrip(req)
+}
+
+// validToken returns the current token, refreshing it first if it expires soon.
+// If the refresh fails while the token has not expired yet, the current token is still used.
+func (m *tokenManager) validToken(ctx context.Context) (string, error) {
+	m.mu.RLock()
+	token, expiresAt, rotated := m.token, m.expiresAt, m.rotated
+	m.mu.RUnlock()
+
+	now := m.now()
+	if !rotated && now.Before(expiresAt.Add(-m.refreshBefore)) {
+		return token, nil
+	}
+	refreshed, err := m.refresh(ctx, token)
+	if err != nil {
+		if now.Before(expiresAt) {
+			requestid.Logger(ctx).Warnf("Failed to refresh Keystone token, using it until it expires at %s: %v", expiresAt.Format(time.RFC3339), err)
+			return token, nil
+		}
+		return "", err
+	}
+	return refreshed, nil
+}
+
+// refresh replaces the stale token with a new one. Callers holding the same stale token share
+// a single request, callers whose token has already been replaced get the new one right away.
+func (m *tokenManager) refresh(ctx context.Context, stale string) (string, error) {
+	m.mu.RLock()
+	current := m.token
+	m.mu.RUnlock()
+	if current != stale {
+		return current, nil
+	}
+
+	result := m.refreshes.DoChan(stale, func() (interface{}, error) {
+		// the refresh outlives a caller giving up, others may still be waiting for it. It is
+		// logged with the request ID of the caller starting it.
+		ctx, cancel := context.WithTimeout(requestid.NewContext(context.Background(), requestid.FromContext(ctx)), tokenRefreshTimeout)
+		defer cancel()
+		return m.createToken(ctx)
+	})
+	select {
+	case r := <-result:
+		if r.Err != nil {
+			return "", r.Err
+		}
+		return r.Val.(string), nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// createToken requests a new token with the credentials of the first one. The request is sent
+// through the base transport, as gobizfly's own refresh is not safe for concurrent use.
+func (m *tokenManager) createToken(ctx context.Context) (string, error) {
+	m.mu.RLock()
+	client, request := m.client, m.request
+	m.mu.RUnlock()
+
+	req, err := client.NewRequest(ctx, http.MethodPost, authServiceName, tokenPath, &request)
+	if err != nil {
+		return "", err
+	}
+	resp, err := m.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		return "", fmt.Errorf("failed to refresh token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized {
+		body, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("failed to refresh token: %w: %s", errUnauthorized, strings.TrimSpace(string(body)))
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		body, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("failed to refresh token: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var token gobizfly.Token
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("failed to refresh token: %w", err)
+	}
+	if token.KeystoneToken == "" {
+		return "", fmt.Errorf("failed to refresh token: response holds no token")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.setToken(ctx, &token)
+	// the credentials may have changed again while the token was requested
+	if m.request == request {
+		m.rotated = false
+	}
+	requestid.Logger(ctx).Info("Refreshed Keystone token")
+	return token.KeystoneToken, nil
+}
+
+func isTokenRequest(req *http.Request) bool {
+	return req.Method == http.MethodPost && strings.HasSuffix(req.URL.Path, tokenPath)
+}
+
+// prepare checks the credentials and the token ahead of an API call. gobizfly does not pass the
+// context of a call on to its requests, so RoundTrip only sees a background context.
+func (m *tokenManager) prepare(ctx context.Context) error {
+	m.checkCredentials(ctx)
+	_, err := m.validToken(ctx)
+	return err
+}
+
+// authenticatedDNS prepares the token of every call of a bizflyCloudDNS, so that refreshes are logged
+// with the request ID of the call and given up with it. RoundTrip then finds a valid token.
+type authenticatedDNS struct {
+	bizflyCloudDNS
+	tokens *tokenManager
+}
+
+func newAuthenticatedDNS(client bizflyCloudDNS, tokens *tokenManager) *authenticatedDNS {
+	return &authenticatedDNS{bizflyCloudDNS: client, tokens: tokens}
+}
+
+func (a *authenticatedDNS) ListZones(ctx context.Context, opts *gobizfly.ListOptions) (*gobizfly.ListZoneResp, error) {
+	if err := a.tokens.prepare(ctx); err != nil {
+		return nil, err
+	}
+	return a.bizflyCloudDNS.ListZones(ctx, opts)
+}
+
+func (a *authenticatedDNS) GetZone(ctx context.Context, zoneID string) (*gobizfly.ExtendedZone, error) {
+	if err := a.tokens.prepare(ctx); err != nil {
+		return nil, err
+	}
+	return a.bizflyCloudDNS.GetZone(ctx, zoneID)
+}
+
+func (a *authenticatedDNS) CreateRecord(ctx context.Context, zoneID string, crpl interface{}) (*gobizfly.Record, error) {
+	if err := a.tokens.prepare(ctx); err != nil {
+		return nil, err
+	}
+	return a.bizflyCloudDNS.CreateRecord(ctx, zoneID, crpl)
+}
+
+func (a *authenticatedDNS) UpdateRecord(ctx context.Context, recordID string, urpl interface{}) (*gobizfly.Record, error) {
+	if err := a.tokens.prepare(ctx); err != nil {
+		return nil, err
+	}
+	return a.bizflyCloudDNS.UpdateRecord(ctx, recordID, urpl)
+}
+
+func (a *authenticatedDNS) DeleteRecord(ctx context.Context, recordID string) error {
+	if err := a.tokens.prepare(ctx); err != nil {
+		return err
+	}
+	return a.bizflyCloudDNS.DeleteRecord(ctx, recordID)
+}
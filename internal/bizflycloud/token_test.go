@@ -0,0 +1,259 @@
+package bizflycloud
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/requestid"
+	"github.com/bizflycloud/gobizfly"
+	"github.com/maxatome/go-testdeep/td"
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// keystoneServer issues numbered tokens and only accepts the latest one.
+type keystoneServer struct {
+	*httptest.Server
+	expiresAt string
+	issued    atomic.Int32
+	// rejectAll revokes every token until the next one is issued
+	rejectAll atomic.Bool
+	// rejectDNS rejects every DNS request, even with the latest token
+	rejectDNS atomic.Bool
+	// rejectSecret is a credential secret token requests are rejected for
+	rejectSecret atomic.Value
+	// tokenRequests counts token requests, including rejected ones
+	tokenRequests atomic.Int32
+	// tokenDelay holds token requests back so that concurrent requests pile up
+	tokenDelay atomic.Int64
+	bodies     chan string
+	// secret is the credential secret of the latest token request
+	secret atomic.Value
+	// dnsHandler serves the DNS requests instead of the record stub when set
+	dnsHandler http.Handler
+}
+
+func newKeystoneServer(t *testing.T, expiresAt string) *keystoneServer {
+	s := &keystoneServer{expiresAt: expiresAt, bodies: make(chan string, 100)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/token", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Duration(s.tokenDelay.Load()))
+		s.tokenRequests.Add(1)
+		var request gobizfly.TokenCreateRequest
+		_ = json.NewDecoder(r.Body).Decode(&request)
+		s.secret.Store(request.AppCredSecret)
+		if request.AppCredSecret == s.rejectSecret.Load() {
+			http.Error(w, "invalid credentials", http.StatusUnauthorized)
+			return
+		}
+		n := s.issued.Add(1)
+		s.rejectAll.Store(false)
+		_ = json.NewEncoder(w).Encode(gobizfly.Token{
+			KeystoneToken: fmt.Sprintf("token-%d", n),
+			ExpiresAt:     s.expiresAt,
+		})
+	})
+	mux.HandleFunc("/api/auth/service", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(gobizfly.ServiceList{Services: []*gobizfly.Service{{
+			CanonicalName: dnsServiceName,
+			Region:        "HN",
+			ServiceUrl:    s.URL + "/dns",
+		}}})
+	})
+	mux.HandleFunc("/dns/", func(w http.ResponseWriter, r *http.Request) {
+		if s.dnsHandler != nil {
+			s.dnsHandler.ServeHTTP(w, r)
+			return
+		}
+		if s.rejectAll.Load() || s.rejectDNS.Load() || r.Header.Get(authTokenHeader) != fmt.Sprintf("token-%d", s.issued.Load()) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		s.bodies <- string(body)
+		_ = json.NewEncoder(w).Encode(gobizfly.Record{ID: "R001"})
+	})
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func newTestTokenManager(t *testing.T, s *keystoneServer, now time.Time) (*tokenManager, *gobizfly.Client) {
+	tokens := newTokenManager(nil, 5*time.Minute)
+	tokens.now = func() time.Time { return now }
+	client, err := gobizfly.NewClient(
+		gobizfly.WithAPIUrl(s.URL),
+		gobizfly.WithRegionName("HN"),
+		gobizfly.WithHTTPClient(&http.Client{Transport: tokens}))
+	require.NoError(t, err)
+	err = tokens.authenticate(context.Background(), client, &gobizfly.TokenCreateRequest{
+		AuthMethod:    auth_method,
+		AppCredID:     "id",
+		AppCredSecret: "secret",
+	})
+	require.NoError(t, err)
+	return tokens, client
+}
+
+func TestTokenManagerRefreshesBeforeExpiry(t *testing.T) {
+	s := newKeystoneServer(t, "2024-01-01T01:00:00.000000Z")
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tokens, client := newTestTokenManager(t, s, now)
+	assert.Equal(t, time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC), tokens.expiresAt)
+
+	err := client.DNS.DeleteRecord(context.Background(), "R001")
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), s.issued.Load())
+
+	// within the refresh window the token is replaced before the request is sent
+	tokens.now = func() time.Time { return now.Add(56 * time.Minute) }
+	err = client.DNS.DeleteRecord(context.Background(), "R001")
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), s.issued.Load())
+	assert.Equal(t, "token-2", tokens.token)
+}
+
+func TestTokenManagerLogsRequestID(t *testing.T) {
+	s := newKeystoneServer(t, "2024-01-01T01:00:00Z")
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tokens, client := newTestTokenManager(t, s, now)
+	hook := logtest.NewGlobal()
+	defer hook.Reset()
+
+	// the refresh is logged with the request ID of the call starting it
+	tokens.now = func() time.Time { return now.Add(56 * time.Minute) }
+	dns := newAuthenticatedDNS(newBizflyCloudClient(client), tokens)
+	err := dns.DeleteRecord(requestid.NewContext(context.Background(), "req-1"), "R001")
+	require.NoError(t, err)
+	td.Cmp(t, hook.AllEntries(), td.SuperBagOf(td.Struct(&log.Entry{
+		Message: "Refreshed Keystone token",
+		Data:    log.Fields{requestid.LogField: "req-1"},
+	}, nil)))
+}
+
+func TestTokenManagerRetriesUnauthorized(t *testing.T) {
+	s := newKeystoneServer(t, "2024-01-01T01:00:00Z")
+	_, client := newTestTokenManager(t, s, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	// the token is revoked, the request is sent again with its body after re-authenticating
+	s.rejectAll.Store(true)
+	_, err := client.DNS.UpdateRecord(context.Background(), "R001", gobizfly.UpdateNormalRecordPayload{
+		BaseUpdateRecordPayload: gobizfly.BaseUpdateRecordPayload{Name: "foo", Type: "A", TTL: 60},
+		Data:                    []string{"1.2.3.4"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), s.issued.Load())
+	assert.JSONEq(t, `{"record":{"name":"foo","type":"A","ttl":60,"data":["1.2.3.4"]}}`, <-s.bodies)
+}
+
+func TestTokenManagerUnauthorizedAfterRefresh(t *testing.T) {
+	s := newKeystoneServer(t, "2024-01-01T01:00:00Z")
+	_, client := newTestTokenManager(t, s, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	// the new token is rejected too, the error is returned rather than a 401 gobizfly would refresh on
+	s.rejectDNS.Store(true)
+	err := client.DNS.DeleteRecord(context.Background(), "R001")
+	assert.ErrorIs(t, err, errUnauthorized)
+	assert.Equal(t, int32(2), s.tokenRequests.Load())
+}
+
+func TestTokenManagerSharesRefresh(t *testing.T) {
+	s := newKeystoneServer(t, "2024-01-01T01:00:00Z")
+	_, client := newTestTokenManager(t, s, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	s.tokenDelay.Store(int64(50 * time.Millisecond))
+	s.rejectAll.Store(true)
+	var wg sync.WaitGroup
+	errs := make([]error, 10)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = client.DNS.DeleteRecord(context.Background(), "R001")
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, int32(2), s.issued.Load())
+}
+
+func TestTokenManagerReloadsCredentials(t *testing.T) {
+	s := newKeystoneServer(t, "2024-01-01T01:00:00Z")
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tokens, client := newTestTokenManager(t, s, now)
+	secretFile := filepath.Join(t.TempDir(), "secret")
+	require.NoError(t, os.WriteFile(secretFile, []byte("secret\n"), 0o600))
+	require.NoError(t, tokens.watchCredentials(credentialFiles{secretFile: secretFile}, time.Minute))
+
+	// files are only checked once per interval
+	require.NoError(t, os.WriteFile(secretFile, []byte("rotated\n"), 0o600))
+	require.NoError(t, os.Chtimes(secretFile, now.Add(time.Minute), now.Add(time.Minute)))
+	require.NoError(t, client.DNS.DeleteRecord(context.Background(), "R001"))
+	assert.Equal(t, int32(1), s.issued.Load())
+
+	tokens.now = func() time.Time { return now.Add(time.Minute) }
+	require.NoError(t, client.DNS.DeleteRecord(context.Background(), "R001"))
+	assert.Equal(t, int32(2), s.issued.Load())
+	assert.Equal(t, "rotated", s.secret.Load())
+	assert.Equal(t, "token-2", tokens.token)
+
+	// a file that fails to load keeps the current credentials and token
+	require.NoError(t, os.WriteFile(secretFile, nil, 0o600))
+	require.NoError(t, os.Chtimes(secretFile, now.Add(2*time.Minute), now.Add(2*time.Minute)))
+	tokens.now = func() time.Time { return now.Add(2 * time.Minute) }
+	require.NoError(t, client.DNS.DeleteRecord(context.Background(), "R001"))
+	assert.Equal(t, int32(2), s.issued.Load())
+	assert.Equal(t, "rotated", tokens.request.AppCredSecret)
+}
+
+func TestTokenManagerRotatedToInvalidCredentials(t *testing.T) {
+	s := newKeystoneServer(t, "2024-01-01T01:00:00Z")
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tokens, client := newTestTokenManager(t, s, now)
+	secretFile := filepath.Join(t.TempDir(), "secret")
+	require.NoError(t, os.WriteFile(secretFile, []byte("secret\n"), 0o600))
+	require.NoError(t, tokens.watchCredentials(credentialFiles{secretFile: secretFile}, time.Minute))
+
+	// the rotated credentials are rejected and the current token has been revoked
+	s.rejectSecret.Store("invalid")
+	require.NoError(t, os.WriteFile(secretFile, []byte("invalid\n"), 0o600))
+	require.NoError(t, os.Chtimes(secretFile, now.Add(time.Minute), now.Add(time.Minute)))
+	tokens.now = func() time.Time { return now.Add(time.Minute) }
+	s.rejectAll.Store(true)
+	err := client.DNS.DeleteRecord(context.Background(), "R001")
+	assert.ErrorIs(t, err, errUnauthorized)
+	// the first token, the refresh for the rotation and the one after the 401
+	assert.Equal(t, int32(3), s.tokenRequests.Load())
+}
+
+func TestTokenManagerParseExpiry(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tokens := newTokenManager(nil, time.Minute)
+	tokens.now = func() time.Time { return now }
+
+	for expiresAt, expected := range map[string]time.Time{
+		"2024-01-01T02:00:00Z":        now.Add(2 * time.Hour),
+		"2024-01-01T02:00:00+07:00":   now.Add(-5 * time.Hour),
+		"2024-01-01T02:00:00.000000":  now.Add(2 * time.Hour),
+		"2024-01-01 02:00:00":         now.Add(2 * time.Hour),
+		"not a timestamp":             now.Add(defaultTokenLifetime),
+		"2024-01-01T02:00:00.123456Z": now.Add(2*time.Hour + 123456*time.Microsecond),
+	} {
+		assert.True(t, expected.Equal(tokens.parseExpiry(context.Background(), expiresAt)), expiresAt)
+	}
+}
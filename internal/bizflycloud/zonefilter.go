@@ -0,0 +1,63 @@
+package bizflycloud
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/provider"
+	"github.com/bizflycloud/gobizfly"
+)
+
+const (
+	// zoneStateActive is the state of zones that are served
+	zoneStateActive = "active"
+	// zoneStateInactive is the state of zones that exist but are not served
+	zoneStateInactive = "inactive"
+	// zoneStateDeleted is the state of zones that were deleted
+	zoneStateDeleted = "deleted"
+)
+
+// zoneFilter selects the zones managed by the provider by ID and state, on top of the domain filter.
+type zoneFilter struct {
+	ids provider.ZoneIDFilter
+	// states holds the selected zone states, all states are selected when it is empty
+	states map[string]bool
+}
+
+// newZoneFilter returns a filter for the given zone IDs and states, empty lists select all zones.
+func newZoneFilter(ids provider.ZoneIDFilter, states []string) (zoneFilter, error) {
+	filter := zoneFilter{ids: ids, states: map[string]bool{}}
+	for _, state := range states {
+		state = strings.ToLower(strings.TrimSpace(state))
+		switch state {
+		case "":
+			continue
+		case zoneStateActive, zoneStateInactive, zoneStateDeleted:
+			filter.states[state] = true
+		default:
+			return zoneFilter{}, fmt.Errorf("unknown zone state '%s', supported are '%s', '%s' and '%s'",
+				state, zoneStateActive, zoneStateInactive, zoneStateDeleted)
+		}
+	}
+	return filter, nil
+}
+
+// Match tells whether the zone passes the filter.
+func (f zoneFilter) Match(zone gobizfly.Zone) bool {
+	if !f.ids.Match(zone.ID) {
+		return false
+	}
+	return len(f.states) == 0 || f.states[zoneState(zone)]
+}
+
+// zoneState returns the state of a zone, deleted zones are deleted regardless of whether they are active.
+func zoneState(zone gobizfly.Zone) string {
+	switch {
+	case zone.Deleted != 0:
+		return zoneStateDeleted
+	case zone.Active:
+		return zoneStateActive
+	default:
+		return zoneStateInactive
+	}
+}
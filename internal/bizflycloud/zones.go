@@ -0,0 +1,75 @@
+package bizflycloud
+
+import (
+	"context"
+	"fmt"
+)
+
+const (
+	// ExcludedByDomainFilter tells that the domain filter does not match the zone name
+	ExcludedByDomainFilter = "domain filter"
+	// ExcludedByZoneIDFilter tells that the zone ID filter does not contain the zone ID
+	ExcludedByZoneIDFilter = "zone ID filter"
+	// ExcludedByZoneStateFilter tells that the zone state filter does not select the state of the zone
+	ExcludedByZoneStateFilter = "zone state filter"
+)
+
+// ZoneStatus describes a zone of an account and whether it is managed by the webhook.
+type ZoneStatus struct {
+	// Account is the name of the account serving the zone, empty for a single account
+	Account string
+	ID      string
+	Name    string
+	State   string
+	// Excluded is the filter excluding the zone, it is empty for managed zones
+	Excluded string
+	// Records counts the supported records of a managed zone by type
+	Records map[string]int
+}
+
+// ZoneStatuses lists all zones of the account, including the ones excluded by the filters. The records
+// of the managed zones are counted.
+func (p *BizflyCloudProvider) ZoneStatuses(ctx context.Context) ([]ZoneStatus, error) {
+	zones, err := p.listZonesMatching(ctx, func(string) bool { return true })
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]ZoneStatus, 0, len(zones))
+	for _, zone := range zones {
+		status := ZoneStatus{Account: p.account, ID: zone.ID, Name: zone.Name, State: zoneState(zone)}
+		switch {
+		case !p.domainFilter.Match(zone.Name):
+			status.Excluded = ExcludedByDomainFilter
+		case !p.zoneFilter.ids.Match(zone.ID):
+			status.Excluded = ExcludedByZoneIDFilter
+		case !p.zoneFilter.Match(zone):
+			status.Excluded = ExcludedByZoneStateFilter
+		default:
+			detailZone, err := p.getZone(ctx, zone.ID)
+			if err != nil {
+				return nil, err
+			}
+			status.Records = map[string]int{}
+			for _, r := range detailZone.RecordsSet {
+				if SupportedRecordType(r.Type) {
+					status.Records[r.Type]++
+				}
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// ZoneStatuses lists the zones of all accounts.
+func (m *multiAccountProvider) ZoneStatuses(ctx context.Context) ([]ZoneStatus, error) {
+	statuses := []ZoneStatus{}
+	for _, account := range m.accounts {
+		accountStatuses, err := account.ZoneStatuses(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("account %s: %w", account.account, err)
+		}
+		statuses = append(statuses, accountStatuses...)
+	}
+	return statuses, nil
+}
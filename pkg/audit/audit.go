@@ -0,0 +1,93 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// Stdout writes the audit log to stdout instead of a file
+	Stdout = "stdout"
+
+	// ResultApplied marks mutations the API accepted
+	ResultApplied = "applied"
+	// ResultFailed marks mutations the API rejected or that could not be sent
+	ResultFailed = "failed"
+)
+
+// Event describes a single mutation sent to the DNS API
+type Event struct {
+	Time          time.Time `json:"timestamp"`
+	CorrelationID string    `json:"correlationId,omitempty"`
+	Account       string    `json:"account,omitempty"`
+	ZoneID        string    `json:"zoneId"`
+	Zone          string    `json:"zone"`
+	Record        string    `json:"record"`
+	Type          string    `json:"type"`
+	SetIdentifier string    `json:"setIdentifier,omitempty"`
+	Action        string    `json:"action"`
+	RecordID      string    `json:"recordId,omitempty"`
+	// Old is the record before an update or delete
+	Old interface{} `json:"old,omitempty"`
+	// New is the payload of a create or update
+	New    interface{} `json:"new,omitempty"`
+	Result string      `json:"result"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// Sink appends events as JSON lines. A nil Sink discards all events, so callers don't need to check
+// whether auditing is enabled.
+type Sink struct {
+	mu  sync.Mutex
+	w   io.Writer
+	now func() time.Time
+}
+
+// Open returns a sink appending to the file at path, or writing to stdout for "stdout".
+// It returns nil if path is empty.
+func Open(path string) (*Sink, error) {
+	switch path {
+	case "":
+		return nil, nil
+	case Stdout:
+		return NewSink(os.Stdout), nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	return NewSink(f), nil
+}
+
+// NewSink returns a sink writing to w
+func NewSink(w io.Writer) *Sink {
+	return &Sink{w: w, now: time.Now}
+}
+
+// Write appends an event, setting its time if it has none. Failures are logged, as a mutation that
+// already happened can not be undone.
+func (s *Sink) Write(event Event) {
+	if s == nil {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = s.now().UTC()
+	}
+	line, err := json.Marshal(event)
+	if err != nil {
+		log.WithField("error", err).Error("failed to encode audit event")
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// a single write per line keeps lines intact when the file is shared
+	if _, err := s.w.Write(append(line, '\n')); err != nil {
+		log.WithField("error", err).Error("failed to write audit event")
+	}
+}
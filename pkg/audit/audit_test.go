@@ -0,0 +1,33 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSink(t *testing.T) {
+	sink, err := Open("")
+	require.NoError(t, err)
+	require.Nil(t, sink)
+	// a disabled sink discards events
+	sink.Write(Event{Action: "CREATE"})
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+	require.NoError(t, os.WriteFile(path, []byte("{}\n"), 0o600))
+	sink, err = Open(path)
+	require.NoError(t, err)
+	sink.now = func() time.Time { return time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC) }
+	sink.Write(Event{ZoneID: "Z001", Zone: "example.com", Record: "a.example.com", Type: "A", Action: "DELETE", RecordID: "R001", Result: ResultApplied})
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	// events are appended to the existing log
+	require.Equal(t, "{}\n"+`{"timestamp":"2024-01-02T03:04:05Z","zoneId":"Z001","zone":"example.com","record":"a.example.com","type":"A","action":"DELETE","recordId":"R001","result":"applied"}`+"\n", string(content))
+
+	_, err = Open(filepath.Join(t.TempDir(), "missing", "audit.log"))
+	require.Error(t, err)
+}
@@ -0,0 +1,37 @@
+package filewatch
+
+import (
+	"os"
+	"time"
+)
+
+// ModTimes returns the modification times of the files, which are polled to notice files replaced in
+// place, e.g. rotated certificates and credentials. Empty names are skipped and have the zero time.
+func ModTimes(files ...string) ([]time.Time, error) {
+	modTimes := make([]time.Time, len(files))
+	for i, file := range files {
+		if file == "" {
+			continue
+		}
+		// Stat follows the symlinks Kubernetes swaps when a mounted secret changes
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		modTimes[i] = info.ModTime()
+	}
+	return modTimes, nil
+}
+
+// Changed tells whether any modification time differs between two polls of the same files.
+func Changed(previous, current []time.Time) bool {
+	if len(previous) != len(current) {
+		return true
+	}
+	for i := range previous {
+		if !previous[i].Equal(current[i]) {
+			return true
+		}
+	}
+	return false
+}
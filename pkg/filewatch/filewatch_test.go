@@ -0,0 +1,33 @@
+package filewatch
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestModTimes(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "secret")
+	require.NoError(t, os.WriteFile(file, []byte("first"), 0o600))
+
+	previous, err := ModTimes(file, "")
+	require.NoError(t, err)
+	assert.True(t, previous[1].IsZero())
+
+	current, err := ModTimes(file, "")
+	require.NoError(t, err)
+	assert.False(t, Changed(previous, current))
+
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(file, later, later))
+	current, err = ModTimes(file, "")
+	require.NoError(t, err)
+	assert.True(t, Changed(previous, current))
+
+	_, err = ModTimes(filepath.Join(t.TempDir(), "missing"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
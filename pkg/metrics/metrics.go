@@ -0,0 +1,128 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "bizflycloud_webhook"
+
+// Registry holds the metrics of the webhook, served by Handler
+var Registry = prometheus.NewRegistry()
+
+var (
+	// RequestsTotal counts webhook requests per route and status code
+	RequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Number of webhook requests by handler and status code.",
+	}, []string{"handler", "code"})
+
+	// RequestDuration observes the latency of webhook requests per route
+	RequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of webhook requests by handler.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"handler"})
+
+	// APICallsTotal counts Bizfly API calls per method
+	APICallsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "api_calls_total",
+		Help:      "Number of Bizfly API calls by method.",
+	}, []string{"method"})
+
+	// APIErrorsTotal counts failed Bizfly API calls per method
+	APIErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "api_errors_total",
+		Help:      "Number of failed Bizfly API calls by method.",
+	}, []string{"method"})
+
+	// APICallDuration observes the latency of Bizfly API calls per method
+	APICallDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "api_call_duration_seconds",
+		Help:      "Latency of Bizfly API calls by method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+
+	// APIRateLimitWait observes how long Bizfly API calls waited for the client-side rate limiter
+	APIRateLimitWait = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "api_rate_limit_wait_seconds",
+		Help:      "Time Bizfly API calls waited for the client-side rate limiter.",
+		Buckets:   []float64{0, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+	})
+
+	// Records is the number of records managed per zone and type, as of the last listing
+	Records = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "records",
+		Help:      "Number of records by zone and type as of the last listing.",
+	}, []string{"zone", "type"})
+
+	// ChangesTotal counts record changes per action and result, either applied or failed
+	ChangesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "changes_total",
+		Help:      "Number of record changes by action and result.",
+	}, []string{"action", "result"})
+)
+
+const (
+	// ResultApplied labels changes that were applied
+	ResultApplied = "applied"
+	// ResultFailed labels changes that failed
+	ResultFailed = "failed"
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		RequestsTotal,
+		RequestDuration,
+		APICallsTotal,
+		APIErrorsTotal,
+		APICallDuration,
+		APIRateLimitWait,
+		Records,
+		ChangesTotal,
+	)
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// InstrumentHandler counts the requests of a webhook route and observes their latency
+func InstrumentHandler(name string, next http.HandlerFunc) http.HandlerFunc {
+	counter := RequestsTotal.MustCurryWith(prometheus.Labels{"handler": name})
+	duration := RequestDuration.MustCurryWith(prometheus.Labels{"handler": name})
+	return promhttp.InstrumentHandlerDuration(duration, promhttp.InstrumentHandlerCounter(counter, next))
+}
+
+// ObserveAPICall records a Bizfly API call that started at start and returned err
+func ObserveAPICall(method string, start time.Time, err error) {
+	APICallsTotal.WithLabelValues(method).Inc()
+	APICallDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+	if err != nil {
+		APIErrorsTotal.WithLabelValues(method).Inc()
+	}
+}
+
+// ObserveChange records the result of a record change
+func ObserveChange(action string, err error) {
+	result := ResultApplied
+	if err != nil {
+		result = ResultFailed
+	}
+	ChangesTotal.WithLabelValues(action, result).Inc()
+}
@@ -0,0 +1,68 @@
+package provider
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RecordError describes a change to a single record that could not be applied
+type RecordError struct {
+	Zone          string `json:"zone,omitempty"`
+	Record        string `json:"record"`
+	Type          string `json:"type"`
+	SetIdentifier string `json:"setIdentifier,omitempty"`
+	Action        string `json:"action"`
+	Reason        string `json:"reason"`
+	Err           error  `json:"-"`
+}
+
+// NewRecordError creates a RecordError whose reason is taken from err
+func NewRecordError(zone, record, recordType, setIdentifier, action string, err error) *RecordError {
+	return &RecordError{
+		Zone:          zone,
+		Record:        record,
+		Type:          recordType,
+		SetIdentifier: setIdentifier,
+		Action:        action,
+		Reason:        err.Error(),
+		Err:           err,
+	}
+}
+
+func (e *RecordError) Error() string {
+	name := e.Record
+	if e.SetIdentifier != "" {
+		name += " (" + e.SetIdentifier + ")"
+	}
+	return fmt.Sprintf("%s %s %s: %s", e.Action, e.Type, name, e.Reason)
+}
+
+func (e *RecordError) Unwrap() error {
+	return e.Err
+}
+
+// ApplyChangesError collects the changes that failed while the remaining ones were applied
+type ApplyChangesError struct {
+	Errors []*RecordError `json:"errors"`
+}
+
+// Add records a failed change
+func (e *ApplyChangesError) Add(err *RecordError) {
+	e.Errors = append(e.Errors, err)
+}
+
+// ErrorOrNil returns nil if no change failed, so the collector can be returned as is
+func (e *ApplyChangesError) ErrorOrNil() error {
+	if e == nil || len(e.Errors) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (e *ApplyChangesError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		messages[i] = err.Error()
+	}
+	return fmt.Sprintf("failed to apply %d change(s): %s", len(e.Errors), strings.Join(messages, "; "))
+}
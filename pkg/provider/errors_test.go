@@ -0,0 +1,22 @@
+package provider
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyChangesError(t *testing.T) {
+	applyErr := &ApplyChangesError{}
+	require.NoError(t, applyErr.ErrorOrNil())
+
+	cause := errors.New("quota exceeded")
+	applyErr.Add(NewRecordError("Z001", "a.example.com", "A", "", "CREATE", cause))
+	applyErr.Add(NewRecordError("Z001", "b.example.com", "A", "canary", "DELETE", errors.New("not found")))
+
+	err := applyErr.ErrorOrNil()
+	require.Error(t, err)
+	require.Equal(t, "failed to apply 2 change(s): CREATE A a.example.com: quota exceeded; DELETE A b.example.com (canary): not found", err.Error())
+	require.ErrorIs(t, err.(*ApplyChangesError).Errors[0], cause)
+}
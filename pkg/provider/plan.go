@@ -0,0 +1,43 @@
+package provider
+
+import (
+	"context"
+
+	"github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/plan"
+)
+
+// Planner is implemented by providers that can tell which API operations a set of changes results in,
+// without executing them
+type Planner interface {
+	PlanChanges(ctx context.Context, changes *plan.Changes) (*ChangePlan, error)
+}
+
+// ChangePlan lists the API operations applying a set of changes would send, per zone
+type ChangePlan struct {
+	Zones []*ZonePlan `json:"zones"`
+	// Skipped holds the changes that would not be applied, e.g. because no zone matches them
+	Skipped []*RecordError `json:"skipped"`
+}
+
+// ZonePlan holds the operations planned for a single zone, in the order they would be executed
+type ZonePlan struct {
+	ID         string              `json:"id"`
+	Name       string              `json:"name"`
+	Operations []*PlannedOperation `json:"operations"`
+}
+
+// PlannedOperation is a single API call a change resolves to. An update may, for example, resolve to
+// the update of one record and the deletion of another one holding the same name.
+type PlannedOperation struct {
+	// Action is the API call, CREATE, UPDATE or DELETE
+	Action string `json:"action"`
+	// ChangeAction is the action of the change the operation belongs to
+	ChangeAction  string `json:"changeAction"`
+	Record        string `json:"record"`
+	Type          string `json:"type"`
+	SetIdentifier string `json:"setIdentifier,omitempty"`
+	// RecordID is the ID of the record that is updated or deleted
+	RecordID string `json:"recordId,omitempty"`
+	// Payload is the request body sent for creates and updates
+	Payload interface{} `json:"payload,omitempty"`
+}
@@ -31,6 +31,17 @@ type Provider interface {
 	GetDomainFilter() endpoint.DomainFilter
 }
 
+// ReadinessChecker is implemented by providers that can tell whether they are able to reach their DNS service
+type ReadinessChecker interface {
+	Ready(ctx context.Context) error
+}
+
+// EndpointAdjuster is implemented by providers that adjust endpoints within the context of the
+// webhook request, e.g. to log with its request ID
+type EndpointAdjuster interface {
+	AdjustEndpointsContext(ctx context.Context, endpoints []*endpoint.Endpoint) []*endpoint.Endpoint
+}
+
 // BaseProvider implements methods of provider interface that are commonly "ignored" by dns providers
 // Basic implementation of the methods is done to avoid code repetition
 type BaseProvider struct {
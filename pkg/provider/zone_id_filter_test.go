@@ -0,0 +1,57 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provider
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestZoneIDFilter(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		zoneIDs    []string
+		configured bool
+		matches    map[string]bool
+	}{
+		{
+			name:    "no filter",
+			zoneIDs: nil,
+			matches: map[string]bool{"Z001": true, "Z002": true},
+		},
+		{
+			name:    "empty value from the environment",
+			zoneIDs: []string{""},
+			matches: map[string]bool{"Z001": true, "Z002": true},
+		},
+		{
+			name:       "filter",
+			zoneIDs:    []string{"Z001", " Z003 "},
+			configured: true,
+			matches:    map[string]bool{"Z001": true, "Z002": false, "Z003": true, "Z00": false},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			filter := NewZoneIDFilter(tc.zoneIDs)
+			assert.Equal(t, tc.configured, filter.IsConfigured())
+			for zoneID, match := range tc.matches {
+				assert.Equal(t, match, filter.Match(zoneID), zoneID)
+			}
+		})
+	}
+}
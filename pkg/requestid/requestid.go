@@ -0,0 +1,75 @@
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// Header carries the request ID of a webhook request, it is generated when the caller sends none
+	Header = "X-Request-ID"
+	// LogField is the log field holding the request ID
+	LogField = "requestID"
+	// maxLength bounds the request IDs accepted from callers, longer ones are replaced
+	maxLength = 128
+)
+
+type contextKey struct{}
+
+// New returns a random request ID
+func New() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// NewContext returns a context carrying the request ID
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the request ID of the context, or an empty string if it has none
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// Logger returns a log entry holding the request ID of the context, if it has one
+func Logger(ctx context.Context) *log.Entry {
+	if id := FromContext(ctx); id != "" {
+		return log.WithField(LogField, id)
+	}
+	return log.NewEntry(log.StandardLogger())
+}
+
+// Middleware puts the request ID sent by the caller, or a new one, into the request context and
+// echoes it in the response
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(Header)
+		if !valid(id) {
+			id = New()
+		}
+		w.Header().Set(Header, id)
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), id)))
+	})
+}
+
+// valid accepts non-empty IDs of printable ASCII characters, so that they can be logged as they are
+func valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
@@ -1,21 +1,30 @@
 package webhook
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/endpoint"
 	"github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/plan"
 	"github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/provider"
+	"github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/requestid"
+	"github.com/bizflycloud/external-dns-bizflycloud-webhook/pkg/tracing"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
 	mediaTypeFormat        = "application/external.dns.webhook+json;"
 	contentTypeHeader      = "Content-Type"
 	contentTypePlaintext   = "text/plain"
+	contentTypeJSON        = "application/json"
 	acceptHeader           = "Accept"
 	varyHeader             = "Vary"
 	supportedMediaVersions = "1"
@@ -23,6 +32,8 @@ const (
 	logFieldRequestPath    = "requestPath"
 	logFieldRequestMethod  = "requestMethod"
 	logFieldError          = "error"
+	// readinessTimeout bounds the check of the provider behind a readiness probe
+	readinessTimeout = 10 * time.Second
 )
 
 var mediaTypeVersion1 = mediaTypeVersion("1")
@@ -75,6 +86,37 @@ func Health(next http.Handler) http.Handler {
 	})
 }
 
+// Liveness handles the liveness probe, it only tells that the process is serving requests
+func Liveness(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(contentTypeHeader, contentTypePlaintext)
+	w.WriteHeader(http.StatusOK)
+	if _, writeError := fmt.Fprint(w, "ok"); writeError != nil {
+		requestLog(r).WithField(logFieldError, writeError).Error("error writing response")
+	}
+}
+
+// Readiness handles the readiness probe, it fails while the provider can not reach its DNS service
+func (p *Webhook) Readiness(w http.ResponseWriter, r *http.Request) {
+	if checker, ok := p.provider.(provider.ReadinessChecker); ok {
+		ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+		defer cancel()
+		if err := checker.Ready(ctx); err != nil {
+			requestLog(r).WithField(logFieldError, err).Warn("provider is not ready")
+			w.Header().Set(contentTypeHeader, contentTypePlaintext)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			if _, writeError := fmt.Fprint(w, err.Error()); writeError != nil {
+				requestLog(r).WithField(logFieldError, writeError).Error("error writing response")
+			}
+			return
+		}
+	}
+	w.Header().Set(contentTypeHeader, contentTypePlaintext)
+	w.WriteHeader(http.StatusOK)
+	if _, writeError := fmt.Fprint(w, "ok"); writeError != nil {
+		requestLog(r).WithField(logFieldError, writeError).Error("error writing response")
+	}
+}
+
 func (p *Webhook) contentTypeHeaderCheck(w http.ResponseWriter, r *http.Request) error {
 	return p.headerCheck(true, w, r)
 }
@@ -132,14 +174,17 @@ func (p *Webhook) Records(w http.ResponseWriter, r *http.Request) {
 		requestLog(r).WithField(logFieldError, err).Error("accept header check failed")
 		return
 	}
+	ctx, span := startSpan(r, "Records")
+	defer span.End()
 	requestLog(r).Debug("requesting records")
-	ctx := r.Context()
 	records, err := p.provider.Records(ctx)
 	if err != nil {
+		tracing.Fail(span, err)
 		requestLog(r).WithField(logFieldError, err).Error("error getting records")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	span.SetAttributes(attribute.Int("records", len(records)))
 	requestLog(r).Debugf("returning records count: %d", len(records))
 	w.Header().Set(contentTypeHeader, string(mediaTypeVersion1))
 	w.Header().Set(varyHeader, contentTypeHeader)
@@ -157,9 +202,11 @@ func (p *Webhook) ApplyChanges(w http.ResponseWriter, r *http.Request) {
 		requestLog(r).WithField(logFieldError, err).Error("content type header check failed")
 		return
 	}
+	ctx, span := startSpan(r, "ApplyChanges")
+	defer span.End()
 	var changes plan.Changes
-	ctx := r.Context()
 	if err := json.NewDecoder(r.Body).Decode(&changes); err != nil {
+		tracing.Fail(span, err)
 		w.Header().Set(contentTypeHeader, contentTypePlaintext)
 		w.WriteHeader(http.StatusBadRequest)
 		errMsg := fmt.Sprintf("error decoding changes: %s", err.Error())
@@ -171,40 +218,114 @@ func (p *Webhook) ApplyChanges(w http.ResponseWriter, r *http.Request) {
 	}
 	requestLog(r).Debugf("requesting apply changes, create: %d , updateOld: %d, updateNew: %d, delete: %d",
 		len(changes.Create), len(changes.UpdateOld), len(changes.UpdateNew), len(changes.Delete))
+	span.SetAttributes(
+		attribute.Int("changes.create", len(changes.Create)),
+		attribute.Int("changes.update", len(changes.UpdateNew)),
+		attribute.Int("changes.delete", len(changes.Delete)))
 	if err := p.provider.ApplyChanges(ctx, &changes); err != nil {
+		tracing.Fail(span, err)
+		requestLog(r).WithField(logFieldError, err).Error("error applying changes")
+		var applyErr *provider.ApplyChangesError
+		if errors.As(err, &applyErr) {
+			w.Header().Set(contentTypeHeader, contentTypeJSON)
+			w.WriteHeader(http.StatusInternalServerError)
+			if encodeErr := json.NewEncoder(w).Encode(applyErr); encodeErr != nil {
+				requestLog(r).WithField(logFieldError, encodeErr).Error("error encoding failed changes")
+			}
+			return
+		}
 		w.Header().Set(contentTypeHeader, contentTypePlaintext)
 		w.WriteHeader(http.StatusInternalServerError)
+		if _, writeError := fmt.Fprint(w, err.Error()); writeError != nil {
+			requestLog(r).WithField(logFieldError, writeError).Error("error writing error message to response writer")
+		}
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// PlanChanges handles the post request for previewing the API operations of record changes, nothing is changed
+func (p *Webhook) PlanChanges(w http.ResponseWriter, r *http.Request) {
+	if err := p.contentTypeHeaderCheck(w, r); err != nil {
+		requestLog(r).WithField(logFieldError, err).Error("content type header check failed")
+		return
+	}
+	ctx, span := startSpan(r, "PlanChanges")
+	defer span.End()
+	planner, ok := p.provider.(provider.Planner)
+	if !ok {
+		w.Header().Set(contentTypeHeader, contentTypePlaintext)
+		w.WriteHeader(http.StatusNotImplemented)
+		if _, writeError := fmt.Fprint(w, "provider does not support planning changes"); writeError != nil {
+			requestLog(r).WithField(logFieldError, writeError).Error("error writing error message to response writer")
+		}
+		return
+	}
+	var changes plan.Changes
+	if err := json.NewDecoder(r.Body).Decode(&changes); err != nil {
+		tracing.Fail(span, err)
+		w.Header().Set(contentTypeHeader, contentTypePlaintext)
+		w.WriteHeader(http.StatusBadRequest)
+		errMsg := fmt.Sprintf("error decoding changes: %s", err.Error())
+		if _, writeError := fmt.Fprint(w, errMsg); writeError != nil {
+			requestLog(r).WithField(logFieldError, writeError).Error("error writing error message to response writer")
+		}
+		requestLog(r).WithField(logFieldError, err).Info(errMsg)
+		return
+	}
+	requestLog(r).Debugf("requesting plan changes, create: %d , updateOld: %d, updateNew: %d, delete: %d",
+		len(changes.Create), len(changes.UpdateOld), len(changes.UpdateNew), len(changes.Delete))
+	changePlan, err := planner.PlanChanges(ctx, &changes)
+	if err != nil {
+		tracing.Fail(span, err)
+		requestLog(r).WithField(logFieldError, err).Error("error planning changes")
+		w.Header().Set(contentTypeHeader, contentTypePlaintext)
+		w.WriteHeader(http.StatusInternalServerError)
+		if _, writeError := fmt.Fprint(w, err.Error()); writeError != nil {
+			requestLog(r).WithField(logFieldError, writeError).Error("error writing error message to response writer")
+		}
+		return
+	}
+	w.Header().Set(contentTypeHeader, contentTypeJSON)
+	if err := json.NewEncoder(w).Encode(changePlan); err != nil {
+		requestLog(r).WithField(logFieldError, err).Error("error encoding plan")
+	}
+}
+
 // AdjustEndpoints handles the post request for adjusting endpoints
 func (p *Webhook) AdjustEndpoints(w http.ResponseWriter, r *http.Request) {
 	if err := p.contentTypeHeaderCheck(w, r); err != nil {
-		log.Errorf("content type header check failed, request method: %s, request path: %s", r.Method, r.URL.Path)
+		requestLog(r).WithField(logFieldError, err).Error("content type header check failed")
 		return
 	}
 	if err := p.acceptHeaderCheck(w, r); err != nil {
-		log.Errorf("accept header check failed, request method: %s, request path: %s", r.Method, r.URL.Path)
+		requestLog(r).WithField(logFieldError, err).Error("accept header check failed")
 		return
 	}
 
+	ctx, span := startSpan(r, "AdjustEndpoints")
+	defer span.End()
 	var pve []*endpoint.Endpoint
 	if err := json.NewDecoder(r.Body).Decode(&pve); err != nil {
+		tracing.Fail(span, err)
 		w.Header().Set(contentTypeHeader, contentTypePlaintext)
 		w.WriteHeader(http.StatusBadRequest)
 		errMessage := fmt.Sprintf("failed to decode request body: %v", err)
-		log.Infof(errMessage+" , request method: %s, request path: %s", r.Method, r.URL.Path)
+		requestLog(r).WithField(logFieldError, err).Info(errMessage)
 		if _, writeError := fmt.Fprint(w, errMessage); writeError != nil {
 			requestLog(r).WithField(logFieldError, writeError).Fatalf("error writing error message to response writer")
 		}
 		return
 	}
-	log.Debugf("requesting adjust endpoints count: %d", len(pve))
-	pve = p.provider.AdjustEndpoints(pve)
+	requestLog(r).Debugf("requesting adjust endpoints count: %d", len(pve))
+	span.SetAttributes(attribute.Int("endpoints", len(pve)))
+	if adjuster, ok := p.provider.(provider.EndpointAdjuster); ok {
+		pve = adjuster.AdjustEndpointsContext(ctx, pve)
+	} else {
+		pve = p.provider.AdjustEndpoints(pve)
+	}
 	out, _ := json.Marshal(&pve)
-	log.Debugf("return adjust endpoints response, resultEndpointCount: %d", len(pve))
+	requestLog(r).Debugf("return adjust endpoints response, resultEndpointCount: %d", len(pve))
 	w.Header().Set(contentTypeHeader, string(mediaTypeVersion1))
 	w.Header().Set(varyHeader, contentTypeHeader)
 	if _, writeError := fmt.Fprint(w, string(out)); writeError != nil {
@@ -217,9 +338,12 @@ func (p *Webhook) Negotiate(w http.ResponseWriter, r *http.Request) {
 		requestLog(r).WithField(logFieldError, err).Error("accept header check failed")
 		return
 	}
+	_, span := startSpan(r, "Negotiate")
+	defer span.End()
 	b, err := p.provider.GetDomainFilter().MarshalJSON()
 	if err != nil {
-		log.Errorf("failed to marshal domain filter, request method: %s, request path: %s", r.Method, r.URL.Path)
+		tracing.Fail(span, err)
+		requestLog(r).WithField(logFieldError, err).Error("failed to marshal domain filter")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -231,6 +355,14 @@ func (p *Webhook) Negotiate(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// startSpan starts the span of a webhook handler, continuing the trace context sent by external-dns
+func startSpan(r *http.Request, handler string) (context.Context, trace.Span) {
+	return tracing.StartServer(r.Context(), propagation.HeaderCarrier(r.Header), "webhook."+handler,
+		attribute.String("http.method", r.Method),
+		attribute.String("http.route", r.URL.Path))
+}
+
+// requestLog returns a log entry describing the request, including its request ID
 func requestLog(r *http.Request) *log.Entry {
-	return log.WithFields(log.Fields{logFieldRequestMethod: r.Method, logFieldRequestPath: r.URL.Path})
+	return requestid.Logger(r.Context()).WithFields(log.Fields{logFieldRequestMethod: r.Method, logFieldRequestPath: r.URL.Path})
 }